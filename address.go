@@ -0,0 +1,93 @@
+package postbox
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// ErrInvalidAddress is returned when an address could not be parsed
+var ErrInvalidAddress = errors.New("invalid address")
+
+// InvalidAddress represents a single address which could not be parsed
+type InvalidAddress struct {
+	Field   string // (ex: To)
+	Address string
+	Err     error
+}
+
+// AddressError is returned when one or more envelope addresses are invalid.
+// All invalid addresses are included.
+type AddressError struct {
+	Addresses []InvalidAddress
+}
+
+func (e *AddressError) Error() string {
+	invalid := make([]string, len(e.Addresses))
+	for index, address := range e.Addresses {
+		invalid[index] = fmt.Sprintf("%s %q (%s)", address.Field, address.Address, address.Err)
+	}
+
+	return ErrInvalidAddress.Error() + ": " + strings.Join(invalid, "; ")
+}
+
+// Unwrap returns ErrInvalidAddress
+func (e *AddressError) Unwrap() error {
+	return ErrInvalidAddress
+}
+
+// Address represents a single mailbox with an optional display name
+type Address struct {
+	Name  string
+	Email string
+}
+
+// ParseAddress parses a single RFC 5322 address (ex: John Doe <john@example.com>).
+// Encoded-words inside the display name are decoded.
+func ParseAddress(value string) (Address, error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return Address{}, err
+	}
+
+	return Address{Name: address.Name, Email: address.Address}, nil
+}
+
+// ParseAddressList parses a comma separated list of RFC 5322 addresses (ex:
+// John <john@example.com>, Jane <jane@example.com>).
+func ParseAddressList(value string) ([]Address, error) {
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Address, len(list))
+	for index, address := range list {
+		result[index] = Address{Name: address.Name, Email: address.Address}
+	}
+
+	return result, nil
+}
+
+// String formats the address as a RFC 5322 mailbox. Display names containing
+// non-ASCII characters are encoded as RFC 2047 encoded-words.
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Email
+	}
+
+	address := mail.Address{Name: a.Name, Address: a.Email}
+	return address.String()
+}
+
+// Addresses formats the given addresses so they could be used inside the
+// address fields of an Envelope (ex: To, Cc).
+func Addresses(addresses ...Address) []string {
+	result := make([]string, len(addresses))
+	for index, address := range addresses {
+		result[index] = address.String()
+	}
+
+	return result
+}
@@ -0,0 +1,53 @@
+package postbox
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestAddressString test if addresses are formatted as RFC 5322 mailboxes
+func TestAddressString(t *testing.T) {
+	tests := map[string]Address{
+		"john@example.com":                             {Email: "john@example.com"},
+		`"John Doe" <john@example.com>`:                {Name: "John Doe", Email: "john@example.com"},
+		"=?utf-8?q?J=C3=BCrgen?= <jurgen@example.com>": {Name: "Jürgen", Email: "jurgen@example.com"},
+	}
+
+	for expected, address := range tests {
+		if address.String() != expected {
+			t.Fatal("Unexpected address:", address.String(), "expected:", expected)
+		}
+
+		parsed, err := ParseAddress(address.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed != address {
+			t.Fatal("Unexpected parsed address:", parsed)
+		}
+	}
+}
+
+// TestAddressesEnvelope test if addresses could be used inside the envelope fields
+func TestAddressesEnvelope(t *testing.T) {
+	from := Address{Name: "John Doe", Email: "john@example.com"}
+	envelope := Envelope{
+		From:    from.String(),
+		To:      Addresses(Address{Email: "boss@example.com"}, Address{Name: "Dan", Email: "dan@example.com"}),
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	expected := []string{
+		`From: "John Doe" <john@example.com>`,
+		`To: boss@example.com, "Dan" <dan@example.com>`,
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(headers, header+CRLF) {
+			t.Fatal("Expected header not found:", header, headers)
+		}
+	}
+}
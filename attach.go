@@ -0,0 +1,124 @@
+package postbox
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// DefaultContentType represents the content type used when the content type
+// of a file could not be detected.
+const DefaultContentType = "application/octet-stream"
+
+// AttachFile constructs a new file for the file at the given path. The name is
+// set to the base of the path and the content type is detected from the file
+// extension or contents. The file is opened and streamed every time the file
+// is written. The file size is recorded at the time of attaching.
+func AttachFile(path string) (*File, error) {
+	contentType, err := detectFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		Name: filepath.Base(path),
+		Size: info.Size(),
+		Header: map[string][]string{
+			"Content-Type": {contentType},
+		},
+		CopyFunc: func(w io.Writer) error {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+
+			defer file.Close()
+
+			_, err = io.Copy(w, file)
+			return err
+		},
+	}
+
+	return file, nil
+}
+
+// AttachReader constructs a new file copying its content from the given
+// reader. The content type is detected from the file name extension when left
+// empty. The reader is consumed when the file is written and could therefore
+// only be written once. The size of the file could not be estimated (see
+// Envelope.EstimatedSize) unless the Size is set.
+func AttachReader(name string, contentType string, reader io.Reader) *File {
+	return &File{
+		Name: name,
+		Header: map[string][]string{
+			"Content-Type": {detectName(name, contentType)},
+		},
+		CopyFunc: func(w io.Writer) error {
+			_, err := io.Copy(w, reader)
+			return err
+		},
+		once: true,
+	}
+}
+
+// AttachBytes constructs a new file containing the given content. The content
+// type is detected from the file name extension when left empty.
+func AttachBytes(name string, contentType string, content []byte) *File {
+	return &File{
+		Name: name,
+		Size: int64(len(content)),
+		Header: map[string][]string{
+			"Content-Type": {detectName(name, contentType)},
+		},
+		CopyFunc: func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		},
+	}
+}
+
+// detectName returns the given content type or the content type of the given
+// file name extension when left empty
+func detectName(name string, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+
+	return DefaultContentType
+}
+
+// detectFile detects the content type of the file at the given path. The file
+// extension is consulted first, the file contents are sniffed when the
+// extension is unknown.
+func detectFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		return contentType, nil
+	}
+
+	// http.DetectContentType considers at most the first 512 bytes
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buffer[:n]), nil
+}
@@ -0,0 +1,98 @@
+package postbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAttachFile test if files are attached with a detected content type
+func TestAttachFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string][]byte{
+		"logo.png":   {0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0},
+		"report.pdf": []byte("%PDF-1.4 report"),
+		"blob":       {0, 1, 2, 3, 4, 5},
+		"data.json":  []byte(`{"hello": "world"}`),
+		"image":      {0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0},
+	}
+
+	expected := map[string]string{
+		"logo.png":   "image/png",
+		"report.pdf": "application/pdf",
+		"blob":       DefaultContentType,
+		"data.json":  "application/json",
+		"image":      "image/png",
+	}
+
+	for name, content := range tests {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, content, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := AttachFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if file.Name != name {
+			t.Fatal("Unexpected file name:", file.Name)
+		}
+
+		if file.Header["Content-Type"][0] != expected[name] {
+			t.Fatal("Unexpected content type:", name, file.Header["Content-Type"])
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err = file.CopyFunc(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buffer.Bytes(), content) {
+			t.Fatal("Unexpected file content:", buffer.Bytes())
+		}
+	}
+
+	_, err := AttachFile(filepath.Join(dir, "missing.txt"))
+	if !os.IsNotExist(err) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestAttachReader test if in-memory attachments are written base64 encoded
+func TestAttachReader(t *testing.T) {
+	content := []byte("id,name\n1,john\n")
+	files := []*File{
+		AttachReader("report.csv", "text/csv", bytes.NewReader(content)),
+		AttachBytes("data.json", "", content),
+	}
+
+	expected := []string{"text/csv", "application/json"}
+
+	for index, file := range files {
+		envelope := Envelope{
+			From:        "john@example.com",
+			To:          []string{"boss@example.com"},
+			Attachments: []*File{file},
+		}
+
+		output, err := envelope.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(output, "Content-Type: "+expected[index]+CRLF) {
+			t.Fatal("Unexpected content type:", output)
+		}
+
+		if !strings.Contains(output, base64.StdEncoding.EncodeToString(content)) {
+			t.Fatal("Encoded content not found:", output)
+		}
+	}
+}
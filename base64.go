@@ -0,0 +1,129 @@
+package postbox
+
+import (
+	"encoding/base64"
+	"io"
+	"sync"
+)
+
+// crlf is written as line break, avoiding a conversion on every write
+var crlf = []byte(CRLF)
+
+// base64Pool contains the base64 writers which could be reused across parts
+var base64Pool = sync.Pool{
+	New: func() interface{} {
+		return &base64Writer{}
+	},
+}
+
+// base64Chunk represents the amount of bytes encoded at once. The chunk is a
+// multiple of 3 allowing it to be encoded without padding.
+const base64Chunk = 3 * 256
+
+// base64Writer encodes the written content as base64 and wraps the encoded
+// content by inserting a CRLF once the given line length has been reached.
+// Writers are pooled, a writer should not be used once it has been closed.
+type base64Writer struct {
+	writer  io.Writer
+	length  int
+	written int
+	pending [3]byte
+	size    int
+	encoded [base64Chunk / 3 * 4]byte
+	buffer  []byte
+	err     error
+}
+
+// newBase64Writer returns a pooled base64 writer writing lines of the given
+// length to the given writer
+func newBase64Writer(writer io.Writer, length int) *base64Writer {
+	encoder := base64Pool.Get().(*base64Writer)
+	encoder.writer = writer
+	encoder.length = length
+	return encoder
+}
+
+func (b *base64Writer) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	total := len(p)
+
+	if b.size > 0 {
+		n := copy(b.pending[b.size:], p)
+		b.size += n
+		p = p[n:]
+
+		if b.size < len(b.pending) {
+			return total, nil
+		}
+
+		b.encode(b.pending[:])
+		b.size = 0
+	}
+
+	for len(p) >= len(b.pending) && b.err == nil {
+		n := len(p) - len(p)%len(b.pending)
+		if n > base64Chunk {
+			n = base64Chunk
+		}
+
+		b.encode(p[:n])
+		p = p[n:]
+	}
+
+	if b.err != nil {
+		return total - len(p), b.err
+	}
+
+	b.size = copy(b.pending[:], p)
+	return total, nil
+}
+
+// encode encodes the given content and writes the wrapped lines to the
+// underlying writer
+func (b *base64Writer) encode(p []byte) {
+	n := base64.StdEncoding.EncodedLen(len(p))
+	base64.StdEncoding.Encode(b.encoded[:n], p)
+
+	encoded := b.encoded[:n]
+	buffer := b.buffer[:0]
+
+	for len(encoded) > 0 {
+		if b.written == b.length {
+			buffer = append(buffer, CRLF...)
+			b.written = 0
+		}
+
+		size := b.length - b.written
+		if size > len(encoded) {
+			size = len(encoded)
+		}
+
+		buffer = append(buffer, encoded[:size]...)
+		b.written += size
+		encoded = encoded[size:]
+	}
+
+	b.buffer = buffer
+	_, b.err = b.writer.Write(buffer)
+}
+
+// Close flushes any pending content including padding and returns the writer
+// to the pool
+func (b *base64Writer) Close() error {
+	if b.size > 0 && b.err == nil {
+		b.encode(b.pending[:b.size])
+	}
+
+	err := b.err
+
+	b.writer = nil
+	b.written = 0
+	b.size = 0
+	b.err = nil
+	base64Pool.Put(b)
+
+	return err
+}
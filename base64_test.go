@@ -0,0 +1,52 @@
+package postbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// TestBase64Writer test if the written content is base64 encoded and wrapped regardless of the write sizes
+func TestBase64Writer(t *testing.T) {
+	content := make([]byte, 4096)
+	for index := range content {
+		content[index] = byte(index * 7)
+	}
+
+	for _, size := range []int{0, 1, 2, 3, 57, 58, 1000, 4096} {
+		for _, chunk := range []int{1, 2, 5, 768, 4096} {
+			buffer := bytes.NewBuffer(nil)
+			writer := newBase64Writer(buffer, MaxLineLength)
+
+			for offset := 0; offset < size; offset += chunk {
+				end := offset + chunk
+				if end > size {
+					end = size
+				}
+
+				_, err := writer.Write(content[offset:end])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := writer.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(buffer.String(), CRLF)
+			for _, line := range lines {
+				if len(line) > MaxLineLength {
+					t.Fatal("Unexpected line length:", len(line))
+				}
+			}
+
+			expected := base64.StdEncoding.EncodeToString(content[:size])
+			if strings.Join(lines, "") != expected {
+				t.Fatalf("Unexpected encoded content (size %d, chunk %d): %q", size, chunk, buffer.String())
+			}
+		}
+	}
+}
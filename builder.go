@@ -0,0 +1,131 @@
+package postbox
+
+// Builder constructs envelopes through a fluent API
+// (ex: NewEnvelope().From(from).To(to).Subject(subject).Text(body).Build()).
+// A builder should not be reused once the envelope has been built.
+type Builder struct {
+	envelope *Envelope
+	text     *Part
+	html     *Part
+}
+
+// NewEnvelope constructs a new envelope builder
+func NewEnvelope() *Builder {
+	return &Builder{
+		envelope: &Envelope{},
+	}
+}
+
+// From sets the From address
+func (b *Builder) From(address string) *Builder {
+	b.envelope.From = address
+	return b
+}
+
+// Sender sets the Sender address
+func (b *Builder) Sender(address string) *Builder {
+	b.envelope.Sender = address
+	return b
+}
+
+// ReplyTo appends the given addresses to the Reply-To addresses
+func (b *Builder) ReplyTo(addresses ...string) *Builder {
+	b.envelope.ReplyTo = append(b.envelope.ReplyTo, addresses...)
+	return b
+}
+
+// To appends the given addresses to the To recipients
+func (b *Builder) To(addresses ...string) *Builder {
+	b.envelope.To = append(b.envelope.To, addresses...)
+	return b
+}
+
+// Cc appends the given addresses to the Cc recipients
+func (b *Builder) Cc(addresses ...string) *Builder {
+	b.envelope.Cc = append(b.envelope.Cc, addresses...)
+	return b
+}
+
+// Bcc appends the given addresses to the Bcc recipients
+func (b *Builder) Bcc(addresses ...string) *Builder {
+	b.envelope.Bcc = append(b.envelope.Bcc, addresses...)
+	return b
+}
+
+// Subject sets the message subject
+func (b *Builder) Subject(subject string) *Builder {
+	b.envelope.Subject = subject
+	return b
+}
+
+// Header sets the given custom message header
+func (b *Builder) Header(name string, values ...string) *Builder {
+	if b.envelope.Headers == nil {
+		b.envelope.Headers = Headers{}
+	}
+
+	b.envelope.Headers[name] = values
+	return b
+}
+
+// Text sets the quoted-printable text/plain body of the message
+func (b *Builder) Text(body string) *Builder {
+	b.text = &Part{
+		ContentType: "text/plain",
+		Encoding:    QuotedPrintable,
+		ReaderFunc:  content(body),
+	}
+
+	return b
+}
+
+// HTML sets the quoted-printable text/html body of the message. The HTML
+// body is written as alternative after the text body when both are set.
+func (b *Builder) HTML(body string) *Builder {
+	b.html = &Part{
+		ContentType: "text/html",
+		Encoding:    QuotedPrintable,
+		ReaderFunc:  content(body),
+	}
+
+	return b
+}
+
+// Part appends the given parts to the message body
+func (b *Builder) Part(parts ...*Part) *Builder {
+	b.envelope.Parts = append(b.envelope.Parts, parts...)
+	return b
+}
+
+// Embed appends the given files as embedded (inline) files
+func (b *Builder) Embed(files ...*File) *Builder {
+	b.envelope.Embedded = append(b.envelope.Embedded, files...)
+	return b
+}
+
+// Attach appends the given files as attachments
+func (b *Builder) Attach(files ...*File) *Builder {
+	b.envelope.Attachments = append(b.envelope.Attachments, files...)
+	return b
+}
+
+// Build validates and returns the constructed envelope. An error is returned
+// when a required field is missing (ex: ErrNoFrom, ErrNoRecipients).
+func (b *Builder) Build() (*Envelope, error) {
+	parts := make([]*Part, 0, len(b.envelope.Parts)+2)
+	for _, part := range []*Part{b.text, b.html} {
+		if part != nil {
+			parts = append(parts, part)
+		}
+	}
+
+	envelope := *b.envelope
+	envelope.Parts = append(parts, envelope.Parts...)
+
+	err := envelope.required()
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
+}
@@ -0,0 +1,80 @@
+package postbox
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestBuilder test if a complete message could be constructed through the builder
+func TestBuilder(t *testing.T) {
+	report := &File{
+		Name: "report.pdf",
+		Header: map[string][]string{
+			"Content-Type": {"application/pdf"},
+		},
+		CopyFunc: func(w io.Writer) error {
+			_, err := io.WriteString(w, "%PDF-1.4 hello world")
+			return err
+		},
+	}
+
+	envelope, err := NewEnvelope().
+		From("John Doe <john@example.com>").
+		To("boss@example.com").
+		Cc("dan@example.com", "jane@example.com").
+		Bcc("secret@example.com").
+		Subject("hello world").
+		Header("X-Mailer", "postbox").
+		HTML("<p>hello world</p>").
+		Text("hello world").
+		Attach(report).
+		Build()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(envelope.Cc) != 2 || len(envelope.Bcc) != 1 {
+		t.Fatal("Unexpected recipients:", envelope.Cc, envelope.Bcc)
+	}
+
+	headers, output := render(t, envelope)
+
+	expected := []string{
+		"From: John Doe <john@example.com>",
+		"To: boss@example.com",
+		"Subject: hello world",
+		"X-Mailer: postbox",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(headers, header+CRLF) {
+			t.Fatal("Expected header not found:", header, headers)
+		}
+	}
+
+	result := structure(t, output)
+	if result != "multipart/mixed(multipart/alternative(text/plain,text/html),application/pdf)" {
+		t.Fatal("Unexpected structure:", result)
+	}
+}
+
+// TestBuilderValidation test if required fields are validated when building
+func TestBuilderValidation(t *testing.T) {
+	_, err := NewEnvelope().To("boss@example.com").Text("hello world").Build()
+	if !errors.Is(err, ErrNoFrom) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	_, err = NewEnvelope().From("john@example.com").Text("hello world").Build()
+	if !errors.Is(err, ErrNoRecipients) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	_, err = NewEnvelope().From("john@example.com").To("boss@example.com").Build()
+	if !errors.Is(err, ErrEmptyMessage) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
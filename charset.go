@@ -0,0 +1,168 @@
+package postbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Transcoder converts UTF-8 content into a different charset. The returned
+// writer is closed once all content has been written when it implements
+// io.Closer. The encoders of golang.org/x/text/encoding (ex:
+// charmap.Windows1252.NewEncoder()) implement this interface.
+type Transcoder interface {
+	Writer(w io.Writer) io.Writer
+}
+
+// ErrUnrepresentable is returned when content could not be represented inside
+// the target charset
+var ErrUnrepresentable = errors.New("character could not be represented in charset")
+
+// ErrInvalidUTF8 is returned when content which is expected to be UTF-8
+// encoded contains invalid sequences
+var ErrInvalidUTF8 = errors.New("invalid UTF-8 sequence")
+
+// Latin1 transcodes UTF-8 content into ISO-8859-1
+var Latin1 Transcoder = latin1{}
+
+type latin1 struct{}
+
+func (latin1) Writer(w io.Writer) io.Writer {
+	return &latin1Writer{writer: w}
+}
+
+// latin1Writer converts the written UTF-8 content into ISO-8859-1. Incomplete
+// UTF-8 sequences are buffered until the next write.
+type latin1Writer struct {
+	writer  io.Writer
+	pending []byte
+	offset  int
+}
+
+func (l *latin1Writer) Write(p []byte) (int, error) {
+	input := append(l.pending, p...)
+	output := make([]byte, 0, len(input))
+	consumed := 0
+
+	for consumed < len(input) {
+		if !utf8.FullRune(input[consumed:]) {
+			break
+		}
+
+		char, size := utf8.DecodeRune(input[consumed:])
+		if char == utf8.RuneError && size <= 1 {
+			return 0, fmt.Errorf("%w at byte %d", ErrInvalidUTF8, l.offset+consumed)
+		}
+
+		if char > 0xff {
+			return 0, fmt.Errorf("%w: %q (ISO-8859-1) at byte %d", ErrUnrepresentable, char, l.offset+consumed)
+		}
+
+		output = append(output, byte(char))
+		consumed += size
+	}
+
+	l.offset += consumed
+	l.pending = append([]byte(nil), input[consumed:]...)
+
+	_, err := l.writer.Write(output)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close returns an error when the written content ended with an incomplete
+// UTF-8 sequence
+func (l *latin1Writer) Close() error {
+	if len(l.pending) > 0 {
+		return fmt.Errorf("%w at byte %d", ErrInvalidUTF8, l.offset)
+	}
+
+	return nil
+}
+
+// validUTF8 passes UTF-8 content through as is while validating it
+type validUTF8 struct{}
+
+func (validUTF8) Writer(w io.Writer) io.Writer {
+	return &utf8Writer{writer: w}
+}
+
+// utf8Writer returns ErrInvalidUTF8 once an invalid UTF-8 sequence has been
+// written. Incomplete UTF-8 sequences are buffered until the next write.
+type utf8Writer struct {
+	writer  io.Writer
+	pending []byte
+	offset  int
+}
+
+func (u *utf8Writer) Write(p []byte) (int, error) {
+	input := p
+	if len(u.pending) > 0 {
+		input = append(u.pending, p...)
+	}
+
+	consumed := 0
+	for consumed < len(input) {
+		if input[consumed] < utf8.RuneSelf {
+			consumed++
+			continue
+		}
+
+		if !utf8.FullRune(input[consumed:]) {
+			break
+		}
+
+		char, size := utf8.DecodeRune(input[consumed:])
+		if char == utf8.RuneError && size <= 1 {
+			return 0, fmt.Errorf("%w at byte %d", ErrInvalidUTF8, u.offset+consumed)
+		}
+
+		consumed += size
+	}
+
+	u.offset += consumed
+	u.pending = append([]byte(nil), input[consumed:]...)
+
+	_, err := u.writer.Write(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close returns an error when the written content ended with an incomplete
+// UTF-8 sequence
+func (u *utf8Writer) Close() error {
+	if len(u.pending) > 0 {
+		return fmt.Errorf("%w at byte %d", ErrInvalidUTF8, u.offset)
+	}
+
+	return nil
+}
+
+// transcode copies the given reader into the given writer. The content is
+// converted using the given transcoder when set.
+func transcode(writer io.Writer, transcoder Transcoder, reader io.Reader) error {
+	if transcoder == nil {
+		_, err := io.Copy(writer, reader)
+		return err
+	}
+
+	target := transcoder.Writer(writer)
+
+	_, err := io.Copy(target, reader)
+	if err != nil {
+		return err
+	}
+
+	if closer, ok := target.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
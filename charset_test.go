@@ -0,0 +1,103 @@
+package postbox
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestTranscodingLatin1 test if UTF-8 content is transcoded into ISO-8859-1
+func TestTranscodingLatin1(t *testing.T) {
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    Unencoded,
+		Charset:     "ISO-8859-1",
+		Transcoder:  Latin1,
+		Reader:      iotest.OneByteReader(strings.NewReader("café")),
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := part.Write(buffer, "UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buffer.String(), "Content-Type: text/plain; charset=ISO-8859-1"+CRLF) {
+		t.Fatal("Unexpected charset:", buffer.String())
+	}
+
+	if !strings.HasSuffix(buffer.String(), CRLF+"caf\xe9"+CRLF) {
+		t.Fatal("Unexpected body:", buffer.Bytes())
+	}
+}
+
+// TestTranscodingUnrepresentable test if unrepresentable characters result in an error
+func TestTranscodingUnrepresentable(t *testing.T) {
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    QuotedPrintable,
+		Charset:     "ISO-8859-1",
+		Transcoder:  Latin1,
+		Reader:      strings.NewReader("price: 5€"),
+	}
+
+	err := part.Write(bytes.NewBuffer(nil), "UTF-8")
+	if !errors.Is(err, ErrUnrepresentable) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestValidatingUTF8 test if invalid UTF-8 content inside utf-8 text parts results in an error
+func TestValidatingUTF8(t *testing.T) {
+	part := Part{
+		ContentType:  "text/plain",
+		Encoding:     QuotedPrintable,
+		ValidateUTF8: true,
+		Reader:       iotest.OneByteReader(strings.NewReader("café")),
+	}
+
+	err := part.Write(bytes.NewBuffer(nil), "UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []struct {
+		content string
+		message string
+	}{
+		{"caf\xe9 au lait", "invalid UTF-8 sequence at byte 3"},
+		{"café\xff", "invalid UTF-8 sequence at byte 5"},
+		{"café\xe2\x82", "invalid UTF-8 sequence at byte 5"},
+	}
+
+	for _, test := range invalid {
+		part.Reader = iotest.OneByteReader(strings.NewReader(test.content))
+
+		err = part.Write(bytes.NewBuffer(nil), "UTF-8")
+		if !errors.Is(err, ErrInvalidUTF8) || err.Error() != test.message {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	part.Reader = strings.NewReader("caf\xe9")
+	part.Charset = "ISO-8859-1"
+
+	err = part.Write(bytes.NewBuffer(nil), "UTF-8")
+	if err != nil {
+		t.Fatal("Unexpected error for a non utf-8 part:", err)
+	}
+
+	envelope := Envelope{
+		From:         "john@example.com",
+		To:           []string{"boss@example.com"},
+		ValidateUTF8: true,
+		Parts:        text("caf\xe9"),
+	}
+
+	_, err = capture(&envelope)
+	if !errors.Is(err, ErrInvalidUTF8) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
@@ -0,0 +1,147 @@
+package postbox
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+)
+
+// ErrClientClosed is returned when a message is sent using a closed client
+var ErrClientClosed = errors.New("client has been closed")
+
+// Client sends messages over a single reused SMTP connection (ex: for bulk
+// sending). The connection is established on the first send and reset (RSET)
+// before every consecutive message. A new connection is established once the
+// connection could not be reset or failed. Messages are sent one at a time,
+// the client is safe for concurrent use.
+type Client struct {
+	addr   string
+	auth   smtp.Auth
+	mutex  sync.Mutex
+	client *smtp.Client
+	closed bool
+}
+
+// NewClient constructs a new client sending messages to the SMTP server at the
+// given address (ex: smtp.example.com:587). The given auth is optional and
+// only used when set. No connection is established until the first message
+// is sent.
+func NewClient(addr string, auth smtp.Auth) *Client {
+	return &Client{
+		addr: addr,
+		auth: auth,
+	}
+}
+
+// Send renders the given envelope and transmits it over the pooled
+// connection (see the package level Send for the used addresses). A
+// *SendError is returned on failure.
+func (c *Client) Send(e *Envelope) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	message, err := renderMessage(e)
+	if err != nil {
+		return &SendError{Failure: DeliveryFailure, Err: err}
+	}
+
+	client, err := c.connection()
+	if err != nil {
+		return err
+	}
+
+	err = deliver(client, e, message)
+	if err != nil {
+		// Rejected commands and invalid envelopes leave the connection
+		// intact, it is reset before the next message. Network failures
+		// could leave the connection in an unknown state.
+		if broken(err) {
+			c.drop()
+		}
+
+		return &SendError{Failure: DeliveryFailure, Err: err}
+	}
+
+	return nil
+}
+
+// broken checks whether the given error is caused by a network or I/O failure
+// (or an unexpected reply) after which the connection could no longer be used
+func broken(err error) bool {
+	var network net.Error
+	if errors.As(err, &network) {
+		return true
+	}
+
+	var protocol textproto.ProtocolError
+	if errors.As(err, &protocol) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// connection returns the pooled connection after resetting it. A new
+// connection is established when no connection is pooled or the pooled
+// connection could not be reset.
+func (c *Client) connection() (*smtp.Client, error) {
+	if c.client != nil {
+		err := c.client.Reset()
+		if err == nil {
+			return c.client, nil
+		}
+
+		c.drop()
+	}
+
+	client, err := dial(c.addr, c.auth)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+	return client, nil
+}
+
+// drop closes and forgets the pooled connection
+func (c *Client) drop() {
+	if c.client == nil {
+		return
+	}
+
+	c.client.Close()
+	c.client = nil
+}
+
+// Close gracefully ends (QUIT) the pooled connection. Messages could no
+// longer be sent once the client is closed.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	c.closed = true
+
+	if c.client == nil {
+		return nil
+	}
+
+	err := c.client.Quit()
+	c.drop()
+
+	if err != nil {
+		return &SendError{Failure: ConnectionFailure, Err: err}
+	}
+
+	return nil
+}
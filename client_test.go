@@ -0,0 +1,175 @@
+package postbox
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestClientReuse test if consecutive messages are sent over a single connection
+func TestClientReuse(t *testing.T) {
+	var failing int32
+
+	srv := newServer(t, func(command string) string {
+		if command == "RSET" && atomic.LoadInt32(&failing) == 1 {
+			return "421 service not available"
+		}
+
+		return ""
+	})
+
+	envelope := func(to string) *Envelope {
+		return &Envelope{
+			From:  "john@example.com",
+			To:    []string{to},
+			Parts: text("hello world"),
+		}
+	}
+
+	client := NewClient(srv.Addr(), nil)
+
+	for _, to := range []string{"boss@example.com", "dan@example.com", "jane@example.com"} {
+		err := client.Send(envelope(to))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if srv.Connections() != 1 {
+		t.Fatal("Unexpected connections:", srv.Connections())
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 3 || strings.Join(transactions[2].to, ",") != "jane@example.com" {
+		t.Fatal("Unexpected transactions:", transactions)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+
+	err := client.Send(envelope("boss@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.Connections() != 2 || len(srv.Transactions()) != 4 {
+		t.Fatal("Unexpected reconnection:", srv.Connections(), len(srv.Transactions()))
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Send(envelope("boss@example.com"))
+	if !errors.Is(err, ErrClientClosed) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestClientRejected test if the connection is kept once a recipient is rejected
+func TestClientRejected(t *testing.T) {
+	srv := newServer(t, func(command string) string {
+		if command == "RCPT" {
+			return "550 mailbox unavailable"
+		}
+
+		return ""
+	})
+
+	client := NewClient(srv.Addr(), nil)
+	defer client.Close()
+
+	envelope := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	for index := 0; index < 2; index++ {
+		err := client.Send(envelope)
+		assertFailure(t, err, DeliveryFailure)
+	}
+
+	if srv.Connections() != 1 {
+		t.Fatal("Unexpected connections:", srv.Connections())
+	}
+}
+
+// TestClientRenderFailure test if no transaction is completed once the message fails to render
+func TestClientRenderFailure(t *testing.T) {
+	srv := newServer(t, nil)
+	client := NewClient(srv.Addr(), nil)
+	defer client.Close()
+
+	failure := errors.New("connection reset")
+	envelope := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Attachments: []*File{
+			{
+				Name: "report.pdf",
+				CopyFunc: func(writer io.Writer) error {
+					writer.Write([]byte("%PDF-1.4"))
+					return failure
+				},
+			},
+		},
+	}
+
+	err := client.Send(envelope)
+	assertFailure(t, err, DeliveryFailure)
+
+	if !errors.Is(err, failure) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(srv.Transactions()) != 0 {
+		t.Fatal("Unexpected transactions:", srv.Transactions())
+	}
+
+	envelope.Attachments = nil
+
+	err = client.Send(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.Transactions()) != 1 || srv.Connections() != 1 {
+		t.Fatal("Unexpected transactions:", srv.Transactions(), srv.Connections())
+	}
+}
+
+// TestClientInvalidEnvelope test if the connection is kept once an envelope could not be sent
+func TestClientInvalidEnvelope(t *testing.T) {
+	srv := newServer(t, nil)
+	client := NewClient(srv.Addr(), nil)
+	defer client.Close()
+
+	envelope := func(from string) *Envelope {
+		return &Envelope{
+			From:  from,
+			To:    []string{"boss@example.com"},
+			Parts: text("hello world"),
+		}
+	}
+
+	err := client.Send(envelope("john@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Send(envelope("john@@example.com"))
+	assertFailure(t, err, DeliveryFailure)
+
+	err = client.Send(envelope("john@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.Connections() != 1 || len(srv.Transactions()) != 2 {
+		t.Fatal("Unexpected connections:", srv.Connections(), len(srv.Transactions()))
+	}
+}
@@ -0,0 +1,123 @@
+package postbox
+
+import (
+	"io"
+)
+
+// Clone returns a deep copy of the envelope. The address slices, headers,
+// parts and files are copied allowing the clone to be modified without
+// affecting the original (ex: a template send to multiple recipients).
+//
+// Part readers could only be consumed once, a ReaderFunc should be used to
+// render the content multiple times. Readers supporting random access (ex:
+// strings.Reader, bytes.Reader) are re-wired to an independent reader
+// starting at the current offset. Other readers are shared between the
+// original and the clone. A MessageID generated while writing the original
+// is not copied, the clone receives its own identifier once written.
+func (e *Envelope) Clone() *Envelope {
+	clone := *e
+
+	if e.MessageID == e.generatedID {
+		clone.MessageID = ""
+	}
+
+	clone.generatedID = ""
+
+	clone.ReplyTo = cloneStrings(e.ReplyTo)
+	clone.To = cloneStrings(e.To)
+	clone.Cc = cloneStrings(e.Cc)
+	clone.Bcc = cloneStrings(e.Bcc)
+	clone.References = cloneStrings(e.References)
+	clone.Unsubscribe = cloneStrings(e.Unsubscribe)
+	clone.Headers = cloneHeaders(e.Headers)
+
+	if e.Parts != nil {
+		clone.Parts = make([]*Part, len(e.Parts))
+		for index, part := range e.Parts {
+			clone.Parts[index] = part.clone()
+		}
+	}
+
+	clone.Embedded = cloneFiles(e.Embedded)
+	clone.Attachments = cloneFiles(e.Attachments)
+
+	if e.RawParts != nil {
+		clone.RawParts = make([]*RawPart, len(e.RawParts))
+		for index, raw := range e.RawParts {
+			clone.RawParts[index] = &RawPart{Reader: cloneReader(raw.Reader), ReaderFunc: raw.ReaderFunc}
+		}
+	}
+
+	return &clone
+}
+
+// readerAt represents a reader supporting random access (ex: strings.Reader)
+type readerAt interface {
+	io.ReaderAt
+	Size() int64
+	Len() int
+}
+
+// clone returns a copy of the part
+func (p *Part) clone() *Part {
+	clone := *p
+	clone.Headers = cloneHeaders(p.Headers)
+	clone.Reader = cloneReader(p.Reader)
+
+	return &clone
+}
+
+// cloneReader returns an independent reader starting at the current offset
+// when the given reader supports random access, the given reader otherwise
+func cloneReader(reader io.Reader) io.Reader {
+	// Section readers (ex: the readers of a clone) do not expose their length
+	if section, ok := reader.(*io.SectionReader); ok {
+		offset, err := section.Seek(0, io.SeekCurrent)
+		if err == nil {
+			return io.NewSectionReader(section, offset, section.Size()-offset)
+		}
+	}
+
+	if reader, ok := reader.(readerAt); ok {
+		offset := reader.Size() - int64(reader.Len())
+		return io.NewSectionReader(reader, offset, int64(reader.Len()))
+	}
+
+	return reader
+}
+
+func cloneFiles(files []*File) []*File {
+	if files == nil {
+		return nil
+	}
+
+	result := make([]*File, len(files))
+	for index, file := range files {
+		clone := *file
+		clone.Header = cloneHeaders(file.Header)
+		result[index] = &clone
+	}
+
+	return result
+}
+
+func cloneHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+
+	result := make(map[string][]string, len(headers))
+	for property, values := range headers {
+		result[property] = cloneStrings(values)
+	}
+
+	return result
+}
+
+func cloneStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	return append(make([]string, 0, len(values)), values...)
+}
@@ -0,0 +1,120 @@
+package postbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestClone test if modifying the clone does not affect the original envelope
+func TestClone(t *testing.T) {
+	original := &Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Headers: Headers{"X-Mailer": {"postbox"}},
+		Parts:   NewAlternative("hello world", "<p>hello world</p>"),
+		Attachments: []*File{
+			{
+				Name:   "report.pdf",
+				Header: map[string][]string{"Content-Type": {"application/pdf"}},
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.WriteString(w, "%PDF-1.4")
+					return err
+				},
+			},
+		},
+	}
+
+	clone := original.Clone()
+	clone.To[0] = "dan@example.com"
+	clone.To = append(clone.To, "jane@example.com")
+	clone.Subject = "hello dan"
+	clone.Headers["X-Mailer"][0] = "clone"
+	clone.Parts[0].ContentType = "text/markdown"
+	clone.Attachments[0].Header["Content-Type"][0] = "text/plain"
+
+	if len(original.To) != 1 || original.To[0] != "boss@example.com" || original.Subject != "hello world" {
+		t.Fatal("Unexpected original envelope:", original.To, original.Subject)
+	}
+
+	if original.Headers["X-Mailer"][0] != "postbox" || original.Parts[0].ContentType != "text/plain" {
+		t.Fatal("Unexpected original headers or parts:", original.Headers, original.Parts[0].ContentType)
+	}
+
+	if original.Attachments[0].Header["Content-Type"][0] != "application/pdf" {
+		t.Fatal("Unexpected original attachment:", original.Attachments[0].Header)
+	}
+
+	cloned, err := clone.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := original.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []string{cloned, rendered} {
+		if !strings.Contains(output, "<p>hello world</p>") {
+			t.Fatal("Part content not found:", output)
+		}
+	}
+}
+
+// TestCloneOfClone test if the part readers of a cloned clone are independent
+func TestCloneOfClone(t *testing.T) {
+	original := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	clone := original.Clone()
+	nested := clone.Clone()
+
+	for _, envelope := range []*Envelope{nested, clone, original} {
+		output, err := envelope.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(output, "hello world") {
+			t.Fatal("Part content not found:", output)
+		}
+	}
+}
+
+// TestCloneMessageID test if generated message identifiers are not copied
+func TestCloneMessageID(t *testing.T) {
+	original := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: NewAlternative("hello world", "<p>hello world</p>"),
+	}
+
+	_, err := original.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := original.Clone()
+	if clone.MessageID != "" {
+		t.Fatal("Generated message id copied:", clone.MessageID)
+	}
+
+	_, err = clone.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clone.MessageID == "" || clone.MessageID == original.MessageID {
+		t.Fatal("Unexpected message id:", clone.MessageID, original.MessageID)
+	}
+
+	original.MessageID = "<1@example.com>"
+	if original.Clone().MessageID != "<1@example.com>" {
+		t.Fatal("Message id not copied:", original.Clone().MessageID)
+	}
+}
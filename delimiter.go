@@ -0,0 +1,117 @@
+package postbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBoundaryCollision is returned when the content of a part contains a line
+// starting with the delimiter of an enclosing multipart boundary. Such a line
+// would be interpreted as the start of a new part by the receiving client.
+var ErrBoundaryCollision = errors.New("content contains a multipart boundary delimiter")
+
+// delimited is passed to the entities inside a multipart context and records
+// the boundaries of all enclosing multipart contexts
+type delimited struct {
+	io.Writer
+	boundaries []string
+}
+
+// delimit returns a writer recording the given boundary in addition to the
+// boundaries recorded by the given writer
+func delimit(writer io.Writer, boundary string) *delimited {
+	result := &delimited{
+		Writer:     writer,
+		boundaries: []string{boundary},
+	}
+
+	if parent, ok := writer.(*delimited); ok {
+		result.Writer = parent.Writer
+		result.boundaries = append(result.boundaries, parent.boundaries...)
+	}
+
+	return result
+}
+
+// guard returns a writer checking the written content for the delimiters of
+// the boundaries recorded by the given writer. The given writer is returned
+// as is when no boundaries have been recorded.
+func guard(writer io.Writer) io.Writer {
+	parent, ok := writer.(*delimited)
+	if !ok {
+		return writer
+	}
+
+	return newCollisionWriter(parent, parent.boundaries...)
+}
+
+// collisionWriter returns ErrBoundaryCollision once a line starting with the
+// delimiter of one of the given boundaries is written. Only the start of
+// every line is buffered, allowing content to be checked while streaming.
+type collisionWriter struct {
+	writer     io.Writer
+	delimiters [][]byte
+	line       []byte
+	limit      int
+	skip       bool // the current line could not contain a delimiter
+}
+
+// newCollisionWriter constructs a new collision writer for the given
+// boundaries
+func newCollisionWriter(writer io.Writer, boundaries ...string) *collisionWriter {
+	result := &collisionWriter{
+		writer:     writer,
+		delimiters: make([][]byte, len(boundaries)),
+	}
+
+	for index, boundary := range boundaries {
+		result.delimiters[index] = []byte("--" + boundary)
+		if len(result.delimiters[index]) > result.limit {
+			result.limit = len(result.delimiters[index])
+		}
+	}
+
+	result.line = make([]byte, 0, result.limit)
+	return result
+}
+
+func (c *collisionWriter) Write(p []byte) (int, error) {
+	remaining := p
+	for len(remaining) > 0 {
+		end := bytes.IndexByte(remaining, '\n')
+
+		segment := remaining
+		if end >= 0 {
+			segment = remaining[:end]
+		}
+
+		if !c.skip {
+			size := c.limit - len(c.line)
+			if size > len(segment) {
+				size = len(segment)
+			}
+
+			c.line = append(c.line, segment[:size]...)
+
+			for _, delimiter := range c.delimiters {
+				if bytes.HasPrefix(c.line, delimiter) {
+					return 0, fmt.Errorf("%w: %q", ErrBoundaryCollision, delimiter)
+				}
+			}
+
+			c.skip = len(c.line) == c.limit || (len(c.line) > 0 && c.line[0] != '-')
+		}
+
+		if end < 0 {
+			break
+		}
+
+		c.line = c.line[:0]
+		c.skip = false
+		remaining = remaining[end+1:]
+	}
+
+	return c.writer.Write(p)
+}
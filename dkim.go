@@ -0,0 +1,314 @@
+package postbox
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canonicalization represents a DKIM canonicalization algorithm as defined in
+// RFC 6376 section 3.4.
+type Canonicalization string
+
+const (
+	// SimpleCanonicalization tolerates almost no modification of the message
+	SimpleCanonicalization Canonicalization = "simple"
+	// RelaxedCanonicalization tolerates common modifications such as
+	// whitespace replacement and header line refolding
+	RelaxedCanonicalization Canonicalization = "relaxed"
+)
+
+// DKIMHeaders represents the headers signed by default
+var DKIMHeaders = []string{
+	"From",
+	"Sender",
+	"Reply-To",
+	"To",
+	"Cc",
+	"Subject",
+	"Date",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+	"MIME-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// ErrMalformedMessage is returned when a message could not be split into a
+// header block and body
+var ErrMalformedMessage = errors.New("malformed message")
+
+// ErrUnsupportedKey is returned when signing with an unsupported private key
+var ErrUnsupportedKey = errors.New("unsupported private key")
+
+// DKIMSigner signs rendered messages with a DKIM-Signature as defined in
+// RFC 6376. RSA (rsa-sha256) and Ed25519 (ed25519-sha256, RFC 8463) keys are
+// supported.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer // *rsa.PrivateKey or ed25519.PrivateKey
+
+	// Headers contains the names of the headers to be signed, DKIMHeaders is
+	// used when left empty. The From header is always signed.
+	Headers []string
+
+	// HeaderCanonicalization and BodyCanonicalization default to relaxed
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+}
+
+// Sign signs the given rendered message (ex: Envelope.Bytes) and returns the
+// message prefixed with the DKIM-Signature header.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	fields, body, err := splitMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := s.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	header := s.headerCanonicalization()
+	canonical := s.bodyCanonicalization()
+
+	hash := sha256.Sum256(canonicalBody(body, canonical))
+	signed := s.signed(fields)
+
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=" + string(header) + "/" + string(canonical),
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+		"t=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"h=" + strings.Join(signed, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(hash[:]),
+	}
+
+	// The signature is placed on a separate line allowing the header to be
+	// hashed without the signature using the same line folding
+	field := "DKIM-Signature: " + fold(strings.Join(tags, "; ")+";", len("DKIM-Signature: ")) + CRLF + " b="
+
+	digest := sha256.New()
+	for _, value := range selectHeaders(fields, signed) {
+		digest.Write([]byte(canonicalHeader(value, header)))
+	}
+
+	digest.Write([]byte(strings.TrimSuffix(canonicalHeader(field+CRLF, header), CRLF)))
+
+	signature, err := s.sign(digest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	result := bytes.NewBuffer(nil)
+	result.WriteString(field)
+	result.WriteString(base64.StdEncoding.EncodeToString(signature))
+	result.WriteString(CRLF)
+	result.Write(message)
+
+	return result.Bytes(), nil
+}
+
+// algorithm returns the signing algorithm of the configured key
+func (s *DKIMSigner) algorithm() (string, error) {
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+
+	return "", ErrUnsupportedKey
+}
+
+// sign signs the given SHA-256 digest
+func (s *DKIMSigner) sign(digest []byte) ([]byte, error) {
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		return s.Key.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+
+	return s.Key.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// signed returns the names of the headers to be signed
+func (s *DKIMSigner) signed(fields []string) []string {
+	names := s.Headers
+	if len(names) == 0 {
+		names = DKIMHeaders
+	}
+
+	result := []string{"From"}
+	for _, name := range names {
+		if strings.EqualFold(name, "From") {
+			continue
+		}
+
+		// Headers not present inside the message are omitted
+		for _, field := range fields {
+			if strings.EqualFold(fieldName(field), name) {
+				result = append(result, name)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *DKIMSigner) headerCanonicalization() Canonicalization {
+	if s.HeaderCanonicalization == "" {
+		return RelaxedCanonicalization
+	}
+
+	return s.HeaderCanonicalization
+}
+
+func (s *DKIMSigner) bodyCanonicalization() Canonicalization {
+	if s.BodyCanonicalization == "" {
+		return RelaxedCanonicalization
+	}
+
+	return s.BodyCanonicalization
+}
+
+// splitMessage splits the given message into its raw header fields (including
+// folded lines and the trailing CRLF) and body
+func splitMessage(message []byte) ([]string, []byte, error) {
+	separator := []byte(CRLF + CRLF)
+	index := bytes.Index(message, separator)
+	if index < 0 {
+		return nil, nil, ErrMalformedMessage
+	}
+
+	block := string(message[:index+len(CRLF)])
+	body := message[index+len(separator):]
+
+	fields := []string{}
+	for _, line := range strings.SplitAfter(block, CRLF) {
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+
+		fields = append(fields, line)
+	}
+
+	return fields, body, nil
+}
+
+// selectHeaders returns the header fields for the given names. Header fields
+// occurring multiple times are selected from the bottom up as defined in
+// RFC 6376 section 5.4.2.
+func selectHeaders(fields []string, names []string) []string {
+	used := make(map[int]bool)
+	result := make([]string, 0, len(names))
+
+	for _, name := range names {
+		for index := len(fields) - 1; index >= 0; index-- {
+			if used[index] || !strings.EqualFold(fieldName(fields[index]), name) {
+				continue
+			}
+
+			used[index] = true
+			result = append(result, fields[index])
+			break
+		}
+	}
+
+	return result
+}
+
+// fieldName returns the name of the given raw header field
+func fieldName(field string) string {
+	index := strings.Index(field, ":")
+	if index < 0 {
+		return ""
+	}
+
+	return strings.TrimRight(field[:index], " \t")
+}
+
+// canonicalHeader canonicalizes the given raw header field
+func canonicalHeader(field string, canonicalization Canonicalization) string {
+	if canonicalization == SimpleCanonicalization {
+		return field
+	}
+
+	index := strings.Index(field, ":")
+	name := strings.ToLower(strings.TrimRight(field[:index], " \t"))
+
+	value := strings.ReplaceAll(field[index+1:], CRLF, "")
+	value = strings.TrimSpace(collapse(value))
+
+	return name + ":" + value + CRLF
+}
+
+// canonicalBody canonicalizes the given message body
+func canonicalBody(body []byte, canonicalization Canonicalization) []byte {
+	lines := strings.Split(string(body), CRLF)
+
+	if canonicalization == RelaxedCanonicalization {
+		for index, line := range lines {
+			lines[index] = strings.TrimRight(collapse(line), " ")
+		}
+	}
+
+	// Trailing empty lines are ignored
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if canonicalization == SimpleCanonicalization {
+			return []byte(CRLF)
+		}
+
+		return []byte{}
+	}
+
+	return []byte(strings.Join(lines, CRLF) + CRLF)
+}
+
+// collapse reduces all sequences of whitespace into a single space
+func collapse(value string) string {
+	builder := strings.Builder{}
+	whitespace := false
+
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		if char == ' ' || char == '\t' {
+			whitespace = true
+			continue
+		}
+
+		if whitespace {
+			builder.WriteByte(' ')
+			whitespace = false
+		}
+
+		builder.WriteByte(char)
+	}
+
+	if whitespace {
+		builder.WriteByte(' ')
+	}
+
+	return builder.String()
+}
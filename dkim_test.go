@@ -0,0 +1,160 @@
+package postbox
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// TestDKIMCanonicalization test the canonicalization examples of RFC 6376 section 3.4.5
+func TestDKIMCanonicalization(t *testing.T) {
+	headers := []string{"A: X" + CRLF, "B : Y\t" + CRLF + "\tZ  " + CRLF}
+	body := []byte(" C " + CRLF + "D \t E" + CRLF + CRLF + CRLF)
+
+	relaxed := ""
+	simple := ""
+
+	for _, header := range headers {
+		relaxed += canonicalHeader(header, RelaxedCanonicalization)
+		simple += canonicalHeader(header, SimpleCanonicalization)
+	}
+
+	if relaxed != "a:X"+CRLF+"b:Y Z"+CRLF {
+		t.Fatalf("Unexpected relaxed headers: %q", relaxed)
+	}
+
+	if simple != strings.Join(headers, "") {
+		t.Fatalf("Unexpected simple headers: %q", simple)
+	}
+
+	if result := string(canonicalBody(body, RelaxedCanonicalization)); result != " C"+CRLF+"D E"+CRLF {
+		t.Fatalf("Unexpected relaxed body: %q", result)
+	}
+
+	if result := string(canonicalBody(body, SimpleCanonicalization)); result != " C "+CRLF+"D \t E"+CRLF {
+		t.Fatalf("Unexpected simple body: %q", result)
+	}
+
+	if result := string(canonicalBody(nil, SimpleCanonicalization)); result != CRLF {
+		t.Fatalf("Unexpected simple empty body: %q", result)
+	}
+}
+
+// TestDKIMSign test if the DKIM-Signature could be verified with the public key
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := []*DKIMSigner{
+		{Domain: "example.com", Selector: "rsa", Key: rsaKey},
+		{Domain: "example.com", Selector: "ed", Key: edKey},
+		{
+			Domain:                 "example.com",
+			Selector:               "rsa",
+			Key:                    rsaKey,
+			HeaderCanonicalization: SimpleCanonicalization,
+			BodyCanonicalization:   SimpleCanonicalization,
+		},
+	}
+
+	for _, signer := range signers {
+		envelope := Envelope{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com"},
+			Subject: "hello world",
+			Parts:   text("hello   world  "),
+		}
+
+		message, err := envelope.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := signer.Sign(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifyDKIM(t, signed, signer.Key.Public())
+	}
+}
+
+// verifyDKIM verifies the DKIM-Signature of the given message
+func verifyDKIM(t *testing.T, message []byte, key crypto.PublicKey) {
+	fields, body, err := splitMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	field := fields[0]
+	if fieldName(field) != "DKIM-Signature" {
+		t.Fatal("DKIM-Signature is not the first header:", field)
+	}
+
+	tags := map[string]string{}
+	value := strings.NewReplacer(CRLF, "", " ", "", "\t", "").Replace(field[len("DKIM-Signature:"):])
+
+	for _, tag := range strings.Split(value, ";") {
+		pair := strings.SplitN(tag, "=", 2)
+		if len(pair) == 2 {
+			tags[pair[0]] = pair[1]
+		}
+	}
+
+	canonicalization := strings.Split(tags["c"], "/")
+	header, canonical := Canonicalization(canonicalization[0]), Canonicalization(canonicalization[1])
+
+	hash := sha256.Sum256(canonicalBody(body, canonical))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(hash[:]) {
+		t.Fatal("Unexpected body hash:", tags["bh"])
+	}
+
+	digest := sha256.New()
+	for _, selected := range selectHeaders(fields[1:], strings.Split(tags["h"], ":")) {
+		digest.Write([]byte(canonicalHeader(selected, header)))
+	}
+
+	unsigned := field[:strings.LastIndex(field, "b=")+2] + CRLF
+	digest.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, header), CRLF)))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			t.Fatal("Unexpected algorithm:", tags["a"])
+		}
+
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest.Sum(nil), signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			t.Fatal("Unexpected algorithm:", tags["a"])
+		}
+
+		if !ed25519.Verify(key, digest.Sum(nil), signature) {
+			t.Fatal("Invalid signature")
+		}
+	}
+
+	if !strings.HasPrefix(tags["h"], "From:") || !strings.Contains(tags["h"], "Subject") {
+		t.Fatal("Unexpected signed headers:", tags["h"])
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/jeroenrinzema/postbox
 
 go 1.16
+
+require golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
@@ -0,0 +1,365 @@
+package postbox
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidHeaderName is returned when a header field name contains
+// characters which are not allowed as defined in RFC 5322 (ex: CR, LF or a
+// colon).
+var ErrInvalidHeaderName = errors.New("invalid header field name")
+
+// ErrInvalidHeaderValue is returned when a header value contains a CR or LF
+// character which is not part of a folded line. Such values could be used to
+// inject additional headers.
+var ErrInvalidHeaderValue = errors.New("invalid header field value")
+
+// ErrContentHeader is returned when the message headers contain a MIME
+// entity header (ex: Content-Type). Content headers are written by the parts,
+// files and boundaries of the message, a custom value would result in a
+// duplicate header.
+var ErrContentHeader = errors.New("content header not allowed inside message headers")
+
+// validateMessageHeaders checks whether the given message headers do not
+// contain any content headers
+func validateMessageHeaders(headers Headers) error {
+	for property := range headers {
+		if strings.HasPrefix(strings.ToLower(property), "content-") {
+			return fmt.Errorf("%w: %s", ErrContentHeader, property)
+		}
+	}
+
+	return nil
+}
+
+// validateHeaderName checks whether the given header field name only consists
+// of printable US-ASCII characters excluding the colon.
+func validateHeaderName(property string) error {
+	if property == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidHeaderName)
+	}
+
+	for index := 0; index < len(property); index++ {
+		char := property[index]
+		if char < 33 || char > 126 || char == ':' {
+			return fmt.Errorf("%w: %q", ErrInvalidHeaderName, property)
+		}
+	}
+
+	return nil
+}
+
+// validateHeaderValue checks whether the given header value does not contain
+// any CR or LF characters other than a CRLF followed by whitespace (folding).
+func validateHeaderValue(property string, value string) error {
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		if char != '\r' && char != '\n' {
+			continue
+		}
+
+		if !folded(value[index:]) {
+			return fmt.Errorf("%w: %s: %q", ErrInvalidHeaderValue, property, value)
+		}
+
+		index++
+	}
+
+	return nil
+}
+
+// folded checks whether the given value starts with a folded line break, a
+// CRLF followed by a space or horizontal tab.
+func folded(value string) bool {
+	if !strings.HasPrefix(value, CRLF) || len(value) < 3 {
+		return false
+	}
+
+	return value[2] == ' ' || value[2] == '\t'
+}
+
+// headerCasing contains the conventional casing of header names which differs
+// from the canonical MIME header key format (ex: Message-Id)
+var headerCasing = map[string]string{
+	"Content-Id":        "Content-ID",
+	"Content-Md5":       "Content-MD5",
+	"Dkim-Signature":    "DKIM-Signature",
+	"List-Id":           "List-ID",
+	"Message-Id":        "Message-ID",
+	"Mime-Version":      "MIME-Version",
+	"Resent-Message-Id": "Resent-Message-ID",
+	"X-Msmail-Priority": "X-MSMail-Priority",
+}
+
+// canonicalKey returns the canonical casing of the given header name (ex:
+// content-type becomes Content-Type and mime-version becomes MIME-Version)
+func canonicalKey(name string) string {
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	if casing, has := headerCasing[key]; has {
+		return casing
+	}
+
+	return key
+}
+
+// MaxHeaderLineLength represents the recommended maximum length of a header
+// line as defined in RFC 5322.
+const MaxHeaderLineLength = 78
+
+// fold folds the given header value onto continuation lines at whitespace
+// boundaries so lines do not exceed MaxHeaderLineLength where possible. Words
+// are never split, encoded-words therefore remain intact. The given offset
+// represents the amount of characters already written on the first line (ex:
+// "Subject: "). Lines which are already folded are preserved.
+func fold(value string, offset int) string {
+	builder := strings.Builder{}
+	length := offset
+
+	for index := 0; index < len(value); {
+		if strings.HasPrefix(value[index:], CRLF) {
+			builder.WriteString(CRLF)
+			length = 0
+			index += len(CRLF)
+			continue
+		}
+
+		start := index
+		for index < len(value) && (value[index] == ' ' || value[index] == '\t') {
+			index++
+		}
+
+		for index < len(value) && value[index] != ' ' && value[index] != '\t' && value[index] != '\r' {
+			index++
+		}
+
+		word := value[start:index]
+		if length > 0 && index > start && (word[0] == ' ' || word[0] == '\t') && length+len(word) > MaxHeaderLineLength {
+			builder.WriteString(CRLF)
+			length = 0
+		}
+
+		builder.WriteString(word)
+		length += len(word)
+	}
+
+	return builder.String()
+}
+
+// encodeHeader encodes the given header value as RFC 2047 encoded-words when
+// it contains non-ASCII characters. Q-encoding is used when it results in the
+// shorter representation (mostly ASCII text), B-encoding otherwise. Long
+// values are split into multiple encoded-words which are folded onto
+// continuation lines.
+func encodeHeader(value string) string {
+	if ascii(value) {
+		return value
+	}
+
+	encoder := mime.QEncoding
+	if qlength(value) > (len(value)+2)/3*4 {
+		encoder = mime.BEncoding
+	}
+
+	words := encoder.Encode("UTF-8", value)
+	return strings.Join(strings.Split(words, " "), CRLF+" ")
+}
+
+// maxParamLength represents the maximum length of a single parameter value
+// segment before the value is split into RFC 2231 continuations
+const maxParamLength = 60
+
+// encodeParam encodes the given header parameter (ex: filename). ASCII values
+// are written as quoted string while values containing non-ASCII characters
+// are percent-encoded as defined in RFC 2231 using the utf-8 charset and an
+// empty language tag. Long values are split into numbered continuations (ex:
+// filename*0*, filename*1*) without splitting multi-byte characters.
+func encodeParam(name string, value string) []string {
+	if ascii(value) {
+		segments := continuations(value, func(char string) string {
+			return char
+		})
+
+		if len(segments) == 1 {
+			return []string{name + "=" + quote(value)}
+		}
+
+		result := make([]string, len(segments))
+		for index, segment := range segments {
+			result[index] = fmt.Sprintf("%s*%d=%s", name, index, quote(segment))
+		}
+
+		return result
+	}
+
+	segments := continuations(value, percent)
+	if len(segments) == 1 {
+		return []string{name + "*=utf-8''" + segments[0]}
+	}
+
+	result := make([]string, len(segments))
+	for index, segment := range segments {
+		if index == 0 {
+			segment = "utf-8''" + segment
+		}
+
+		result[index] = fmt.Sprintf("%s*%d*=%s", name, index, segment)
+	}
+
+	return result
+}
+
+// continuations splits the given value into segments no longer than
+// maxParamLength once encoded using the given encoder. Characters are never
+// split across segments.
+func continuations(value string, encode func(char string) string) []string {
+	segments := []string{}
+	current := ""
+
+	for index := 0; index < len(value); {
+		_, size := utf8.DecodeRuneInString(value[index:])
+		encoded := encode(value[index : index+size])
+		index += size
+
+		if current != "" && len(current)+len(encoded) > maxParamLength {
+			segments = append(segments, current)
+			current = ""
+		}
+
+		current += encoded
+	}
+
+	return append(segments, current)
+}
+
+// percent percent-encodes all characters of the given value which are not
+// allowed as RFC 2231 attribute-char
+func percent(value string) string {
+	builder := strings.Builder{}
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		if char > ' ' && char < 127 && !strings.ContainsRune(`*'%()<>@,;:\"/[]?=`, rune(char)) {
+			builder.WriteByte(char)
+			continue
+		}
+
+		fmt.Fprintf(&builder, "%%%02X", char)
+	}
+
+	return builder.String()
+}
+
+// encodeAddress encodes the display name of the given address when it
+// contains non-ASCII characters. Addresses which could not be parsed are
+// returned as is.
+func encodeAddress(value string) string {
+	if ascii(value) {
+		return value
+	}
+
+	address, err := ParseAddress(value)
+	if err != nil {
+		return value
+	}
+
+	return address.String()
+}
+
+// encodeAddressList encodes the display names of the given comma separated
+// address list. Values containing a single address are encoded as is.
+func encodeAddressList(value string) string {
+	addresses, err := ParseAddressList(value)
+	if err != nil || len(addresses) < 2 {
+		return encodeAddress(value)
+	}
+
+	return strings.Join(Addresses(addresses...), ", ")
+}
+
+// encodeAddresses encodes the display names of the given addresses
+func encodeAddresses(values []string) []string {
+	result := make([]string, len(values))
+	for index, value := range values {
+		result[index] = encodeAddress(value)
+	}
+
+	return result
+}
+
+// ascii checks whether the given value only contains ASCII characters
+func ascii(value string) bool {
+	for index := 0; index < len(value); index++ {
+		if value[index] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ErrInvalidLanguage is returned when a language tag does not match the basic
+// BCP 47 shape
+var ErrInvalidLanguage = errors.New("invalid language tag")
+
+// ValidateLanguage checks whether the given comma separated language tags
+// (ex: en, de-CH) match the basic BCP 47 shape. The primary subtag has to
+// consist of 1 to 8 letters and all following subtags of 1 to 8 letters or
+// digits. Tags are not checked against the language subtag registry.
+func ValidateLanguage(value string) error {
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+
+		for index, subtag := range strings.Split(tag, "-") {
+			if len(subtag) == 0 || len(subtag) > 8 {
+				return fmt.Errorf("%w: %q", ErrInvalidLanguage, tag)
+			}
+
+			for _, char := range subtag {
+				letter := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+				digit := char >= '0' && char <= '9'
+
+				if !letter && (index == 0 || !digit) {
+					return fmt.Errorf("%w: %q", ErrInvalidLanguage, tag)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// token checks whether the given value is a non-empty RFC 2045 token which
+// could be written as parameter value without quoting
+func token(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		if char <= ' ' || char >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?=`, char) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// qlength returns the approximate length of the given value once Q-encoded
+func qlength(value string) int {
+	length := 0
+	for index := 0; index < len(value); index++ {
+		if value[index] >= utf8.RuneSelf {
+			length += 3
+			continue
+		}
+
+		length++
+	}
+
+	return length
+}
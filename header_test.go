@@ -0,0 +1,562 @@
+package postbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestEncodingSubject test if non-ASCII subjects are written as RFC 2047 encoded-words
+func TestEncodingSubject(t *testing.T) {
+	tests := map[string]string{
+		"Rückmeldung über Ihr Konto":                     "?q?",
+		"Привет мир":                                     "?b?",
+		strings.Repeat("Rückmeldung über Ihr Konto ", 5): "?q?",
+	}
+
+	decoder := mime.WordDecoder{}
+
+	for subject, encoding := range tests {
+		envelope := Envelope{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com"},
+			Subject: subject,
+			Charset: "UTF-8",
+			Parts:   text("hello world"),
+		}
+
+		headers, _ := render(t, &envelope)
+		start := strings.Index(headers, "Subject: ")
+		end := strings.Index(headers[start:], CRLF+"Message-ID")
+		header := headers[start+len("Subject: ") : start+end]
+
+		if !strings.Contains(header, encoding) {
+			t.Fatal("Unexpected encoding:", header)
+		}
+
+		lines := strings.Split(header, CRLF)
+		for _, line := range lines[1:] {
+			if !strings.HasPrefix(line, " ") || len(line) > MaxLineLength {
+				t.Fatal("Subject is not folded correctly:", line)
+			}
+		}
+
+		decoded, err := decoder.DecodeHeader(strings.ReplaceAll(header, CRLF, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded != subject {
+			t.Fatal("Unexpected decoded subject:", decoded)
+		}
+	}
+}
+
+// TestEncodingDisplayName test if non-ASCII display names are encoded
+func TestEncodingDisplayName(t *testing.T) {
+	envelope := Envelope{
+		From:    "Jürgen <jurgen@example.com>",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	if !strings.Contains(headers, "From: =?utf-8?q?J=C3=BCrgen?= <jurgen@example.com>"+CRLF) {
+		t.Fatal("Display name is not encoded:", headers)
+	}
+}
+
+// TestHeaderInjection test if header values containing newlines are rejected
+func TestHeaderInjection(t *testing.T) {
+	tests := []Envelope{
+		{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com"},
+			Subject: "hello\r\nBcc: attacker@evil.com",
+		},
+		{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com\r\nBcc: attacker@evil.com"},
+			Subject: "hello world",
+		},
+		{
+			From:    "john@example.com\nBcc: attacker@evil.com",
+			To:      []string{"boss@example.com"},
+			Subject: "hello world",
+		},
+	}
+
+	for _, envelope := range tests {
+		envelope.Charset = "UTF-8"
+		envelope.Parts = text("hello world")
+
+		output, err := capture(&envelope)
+		if !errors.Is(err, ErrInvalidHeaderValue) {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		if strings.Contains(output, "Bcc") {
+			t.Fatal("Injected header written:", output)
+		}
+	}
+}
+
+// TestHeaderFoldedValue test if folded header values are accepted
+func TestHeaderFoldedValue(t *testing.T) {
+	headers := Headers{
+		"Subject": {"hello" + CRLF + " world"},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := headers.Write(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.String() != "Subject: hello"+CRLF+" world"+CRLF {
+		t.Fatal("Unexpected headers:", buffer.String())
+	}
+}
+
+// TestWritingDisposition test if an inline part with a UTF-8 filename is RFC 2231 encoded
+func TestWritingDisposition(t *testing.T) {
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    QuotedPrintable,
+		Reader:      strings.NewReader("hello world"),
+		Disposition: Inline,
+		Filename:    "résumé 2021.txt",
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := part.Write(buffer, "UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Content-Disposition: inline; filename*=utf-8''r%C3%A9sum%C3%A9%202021.txt" + CRLF
+	if !strings.Contains(buffer.String(), expected) {
+		t.Fatal("Unexpected part:", buffer.String())
+	}
+
+	disposition, params, err := mime.ParseMediaType(strings.TrimPrefix(strings.TrimSuffix(expected, CRLF), "Content-Disposition: "))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if disposition != "inline" || params["filename"] != part.Filename {
+		t.Fatal("Unexpected disposition:", disposition, params)
+	}
+}
+
+// TestWritingDispositionContinuations test if long filenames are split into RFC 2231 continuations
+func TestWritingDispositionContinuations(t *testing.T) {
+	tests := map[string][]string{
+		"Ñandú résumé " + strings.Repeat("ñ", 30) + ".pdf": {
+			"attachment",
+			"filename*0*=utf-8''%C3%91and%C3%BA%20r%C3%A9sum%C3%A9%20%C3%B1%C3%B1%C3%B1",
+			"filename*1*=%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1",
+			"filename*2*=%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1",
+			"filename*3*=%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1%C3%B1.pdf",
+		},
+		strings.Repeat("report-", 10) + "2021.pdf": {
+			"attachment",
+			`filename*0="report-report-report-report-report-report-report-report-repo"`,
+			`filename*1="rt-report-2021.pdf"`,
+		},
+	}
+
+	for name, expected := range tests {
+		file := AttachBytes(name, "application/pdf", []byte("%PDF-1.4"))
+
+		buffer := bytes.NewBuffer(nil)
+		err := file.Write(buffer, Attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := mail.ReadMessage(io.MultiReader(buffer, strings.NewReader(CRLF)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header := parsed.Header.Get("Content-Disposition")
+		params := strings.Split(header, "; ")
+		if strings.Join(params, "\n") != strings.Join(expected, "\n") {
+			t.Fatal("Unexpected disposition:", header)
+		}
+
+		for _, param := range params[1:] {
+			if len(param) > MaxLineLength {
+				t.Fatal("Unexpected parameter length:", param)
+			}
+		}
+
+		_, values, err := mime.ParseMediaType(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if values["filename"] != name {
+			t.Fatal("Unexpected filename:", values["filename"])
+		}
+	}
+}
+
+// TestHeaderFolding test if long header values are folded onto continuation lines
+func TestHeaderFolding(t *testing.T) {
+	cc := make([]string, 20)
+	for index := range cc {
+		cc[index] = "recipient-" + strings.Repeat("x", index) + "@example.com"
+	}
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Cc:      cc,
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	start := strings.Index(headers, "Cc: ")
+	end := start + strings.Index(headers[start:], CRLF+"Subject")
+	header := headers[start:end]
+
+	lines := strings.Split(header, CRLF)
+	if len(lines) < 2 {
+		t.Fatal("Header has not been folded:", header)
+	}
+
+	for index, line := range lines {
+		if len(line) > MaxHeaderLineLength {
+			t.Fatal("Line exceeds the maximum header line length:", line)
+		}
+
+		if index > 0 && !strings.HasPrefix(line, " ") {
+			t.Fatal("Continuation line does not start with whitespace:", line)
+		}
+	}
+
+	if strings.ReplaceAll(header, CRLF, "") != "Cc: "+strings.Join(cc, ", ") {
+		t.Fatal("Unexpected unfolded header:", header)
+	}
+}
+
+// TestHeaderFoldingLongWord test if words exceeding the line length are not split
+func TestHeaderFoldingLongWord(t *testing.T) {
+	word := strings.Repeat("x", 500)
+	headers := Headers{
+		"X-Token": {word + " " + word},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := headers.Write(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "X-Token: " + word + CRLF + " " + word + CRLF
+	if buffer.String() != expected {
+		t.Fatal("Unexpected headers:", buffer.String())
+	}
+}
+
+// TestWritingUnsubscribe test if the List-Unsubscribe headers are written as defined in RFC 8058
+func TestWritingUnsubscribe(t *testing.T) {
+	envelope := Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Parts:       text("hello world"),
+		Unsubscribe: []string{"mailto:unsubscribe@example.com?subject=unsubscribe", "https://example.com/unsubscribe/123"},
+	}
+
+	headers, _ := render(t, &envelope)
+
+	expected := "List-Unsubscribe: <mailto:unsubscribe@example.com?subject=unsubscribe>," + CRLF +
+		" <https://example.com/unsubscribe/123>" + CRLF +
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click" + CRLF
+	if !strings.Contains(headers, expected) {
+		t.Fatal("Unexpected unsubscribe headers:", headers)
+	}
+
+	envelope.Unsubscribe = []string{"mailto:unsubscribe@example.com"}
+	envelope.Parts = text("hello world")
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, "List-Unsubscribe: <mailto:unsubscribe@example.com>"+CRLF) {
+		t.Fatal("Unexpected unsubscribe header:", headers)
+	}
+
+	if strings.Contains(headers, "List-Unsubscribe-Post") {
+		t.Fatal("Unexpected one-click header without https URL:", headers)
+	}
+}
+
+// TestWritingPriority test if the priority headers are written for each priority
+func TestWritingPriority(t *testing.T) {
+	tests := map[Priority][]string{
+		HighPriority: {"Importance: high", "X-MSMail-Priority: High", "X-Priority: 1"},
+		LowPriority:  {"Importance: low", "X-MSMail-Priority: Low", "X-Priority: 5"},
+	}
+
+	for priority, expected := range tests {
+		envelope := Envelope{
+			From:     "john@example.com",
+			To:       []string{"boss@example.com"},
+			Parts:    text("hello world"),
+			Priority: priority,
+		}
+
+		headers, _ := render(t, &envelope)
+		for _, header := range expected {
+			if !strings.Contains(headers, header+CRLF) {
+				t.Fatal("Priority header not found:", priority, header, headers)
+			}
+		}
+	}
+
+	for _, priority := range []Priority{"", NormalPriority} {
+		envelope := Envelope{
+			From:     "john@example.com",
+			To:       []string{"boss@example.com"},
+			Parts:    text("hello world"),
+			Priority: priority,
+		}
+
+		headers, _ := render(t, &envelope)
+		if strings.Contains(headers, "Priority") || strings.Contains(headers, "Importance") {
+			t.Fatal("Unexpected priority headers:", priority, headers)
+		}
+	}
+}
+
+// TestWritingAutoSubmitted test if the Auto-Submitted header is written for each value
+func TestWritingAutoSubmitted(t *testing.T) {
+	values := []AutoSubmitted{NotAutoSubmitted, AutoGenerated, AutoReplied}
+
+	for _, value := range values {
+		envelope := Envelope{
+			From:          "john@example.com",
+			To:            []string{"boss@example.com"},
+			Parts:         text("hello world"),
+			AutoSubmitted: value,
+		}
+
+		headers, _ := render(t, &envelope)
+		if !strings.Contains(headers, "Auto-Submitted: "+string(value)+CRLF) {
+			t.Fatal("Auto-Submitted header not found:", value, headers)
+		}
+	}
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	if strings.Contains(headers, "Auto-Submitted") {
+		t.Fatal("Unexpected Auto-Submitted header:", headers)
+	}
+}
+
+// TestWritingSensitivity test if the Sensitivity header is only written for non normal values
+func TestWritingSensitivity(t *testing.T) {
+	values := []Sensitivity{PersonalSensitivity, PrivateSensitivity, ConfidentialSensitivity}
+
+	for _, value := range values {
+		envelope := Envelope{
+			From:        "john@example.com",
+			To:          []string{"boss@example.com"},
+			Parts:       text("hello world"),
+			Sensitivity: value,
+		}
+
+		headers, _ := render(t, &envelope)
+		if !strings.Contains(headers, "Sensitivity: "+string(value)+CRLF) {
+			t.Fatal("Sensitivity header not found:", value, headers)
+		}
+	}
+
+	for _, value := range []Sensitivity{"", NormalSensitivity} {
+		envelope := Envelope{
+			From:        "john@example.com",
+			To:          []string{"boss@example.com"},
+			Parts:       text("hello world"),
+			Sensitivity: value,
+		}
+
+		headers, _ := render(t, &envelope)
+		if strings.Contains(headers, "Sensitivity") {
+			t.Fatal("Unexpected Sensitivity header:", value, headers)
+		}
+	}
+}
+
+// TestWritingContentLanguage test if the Content-Language header is only written for parts with a language
+func TestWritingContentLanguage(t *testing.T) {
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"boss@example.com"},
+		Parts: []*Part{
+			{ContentType: "text/plain", Encoding: QuotedPrintable, Language: "de", Reader: strings.NewReader("hallo welt")},
+			{ContentType: "text/html", Encoding: QuotedPrintable, Reader: strings.NewReader("<p>hello world</p>")},
+		},
+	}
+
+	_, output := render(t, &envelope)
+
+	languages := []string{}
+	_, err := ReadMessage(strings.NewReader(output), func(part *MessagePart) error {
+		languages = append(languages, part.MediaType+":"+part.Header.Get("Content-Language"))
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(languages, ",") != "text/plain:de,text/html:" {
+		t.Fatal("Unexpected languages:", languages)
+	}
+
+	if strings.Count(output, "Content-Language") != 1 {
+		t.Fatal("Unexpected Content-Language headers:", output)
+	}
+
+	envelope.Language = "en-GB"
+	for _, part := range envelope.Parts {
+		part.Reader = strings.NewReader("hello world")
+	}
+
+	_, output = render(t, &envelope)
+	if !strings.Contains(output, "Content-Language: de"+CRLF) || !strings.Contains(output, "Content-Language: en-GB"+CRLF) {
+		t.Fatal("Unexpected Content-Language headers:", output)
+	}
+
+	envelope.Parts[0].Language = "de_CH"
+	envelope.Parts[0].Reader = strings.NewReader("hallo welt")
+
+	_, err = capture(&envelope)
+	if !errors.Is(err, ErrInvalidLanguage) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestValidateLanguage test if language tags are validated against the basic BCP 47 shape
+func TestValidateLanguage(t *testing.T) {
+	valid := []string{"en", "de-CH", "zh-Hant-TW", "es-419", "x-klingon", "en, de", "sgn-BE-FR"}
+	for _, value := range valid {
+		err := ValidateLanguage(value)
+		if err != nil {
+			t.Fatal("Unexpected error:", value, err)
+		}
+	}
+
+	invalid := []string{"", "en-", "-en", "e1", "en_US", "en,", "languages", "de CH", "en-abcdefghi"}
+	for _, value := range invalid {
+		err := ValidateLanguage(value)
+		if !errors.Is(err, ErrInvalidLanguage) {
+			t.Fatal("Unexpected error:", value, err)
+		}
+	}
+}
+
+// TestHeaderCanonicalKeys test if header names are written with their canonical casing
+func TestHeaderCanonicalKeys(t *testing.T) {
+	headers := Headers{
+		"content-type":        {"text/plain"},
+		"content-id":          {"<logo>"},
+		"message-id":          {"<1@example.com>"},
+		"mime-version":        {"1.0"},
+		"x-custom-header":     {"hello"},
+		"DKIM-SIGNATURE":      {"v=1"},
+		"x-msmail-priority":   {"High"},
+		"Content-Disposition": {"inline"},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := headers.Write(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Message-ID: <1@example.com>" + CRLF +
+		"Content-Disposition: inline" + CRLF +
+		"Content-ID: <logo>" + CRLF +
+		"Content-Type: text/plain" + CRLF +
+		"DKIM-Signature: v=1" + CRLF +
+		"MIME-Version: 1.0" + CRLF +
+		"X-Custom-Header: hello" + CRLF +
+		"X-MSMail-Priority: High" + CRLF
+
+	if buffer.String() != expected {
+		t.Fatal("Unexpected headers:", buffer.String())
+	}
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Parts:   text("hello world"),
+		Headers: Headers{"subject": {"overridden"}},
+	}
+
+	output, _ := render(t, &envelope)
+	if strings.Count(output, "Subject:") != 1 || !strings.Contains(output, "Subject: overridden"+CRLF) {
+		t.Fatal("Unexpected subject header:", output)
+	}
+}
+
+// TestHeaderRepeated test if repeated headers are written as separate header lines
+func TestHeaderRepeated(t *testing.T) {
+	headers := Headers{
+		"Content-Type": {"text/plain", "charset=utf-8"},
+		"received": {
+			"from mx.example.com by example.org; Tue, 10 Nov 2009 23:00:00 +0100",
+			"from localhost by mx.example.com; Tue, 10 Nov 2009 22:59:59 +0100",
+		},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := headers.Write(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Received: from mx.example.com by example.org; Tue, 10 Nov 2009 23:00:00 +0100" + CRLF +
+		"Received: from localhost by mx.example.com; Tue, 10 Nov 2009 22:59:59 +0100" + CRLF +
+		"Content-Type: text/plain; charset=utf-8" + CRLF
+
+	if buffer.String() != expected {
+		t.Fatal("Unexpected headers:", buffer.String())
+	}
+}
+
+// TestHeaderAddressSeparator test if address headers are comma separated while parameters are semicolon separated
+func TestHeaderAddressSeparator(t *testing.T) {
+	headers := Headers{
+		"To":           {"boss@example.com", "Dan <dan@example.com>"},
+		"cc":           {"jane@example.com", "john@example.com"},
+		"Content-Type": {"text/plain", "charset=utf-8"},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := headers.Write(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "To: boss@example.com, Dan <dan@example.com>" + CRLF +
+		"Cc: jane@example.com, john@example.com" + CRLF +
+		"Content-Type: text/plain; charset=utf-8" + CRLF
+
+	if buffer.String() != expected {
+		t.Fatal("Unexpected headers:", buffer.String())
+	}
+}
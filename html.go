@@ -0,0 +1,183 @@
+package postbox
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// hrefAttribute matches the href attribute of an anchor tag
+var hrefAttribute = regexp.MustCompile(`(?i)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// GenerateTextFromHTML derives a best-effort plain text representation of the
+// given HTML content. Tags are stripped, whitespace is collapsed and block
+// elements (ex: p, div, br) are written as line breaks. Link targets are
+// preserved behind the link text (ex: "example (https://example.com)"). The
+// contents of script, style and head elements are omitted.
+func GenerateTextFromHTML(content string) string {
+	result := []byte{}
+	space := false
+	skip := ""
+
+	links := []int{}
+	hrefs := []string{}
+
+	write := func(value string) {
+		for index := 0; index < len(value); index++ {
+			char := value[index]
+			if char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == '\f' {
+				space = true
+				continue
+			}
+
+			if space && len(result) > 0 && result[len(result)-1] != '\n' {
+				result = append(result, ' ')
+			}
+
+			space = false
+			result = append(result, char)
+		}
+	}
+
+	// newline ensures the result ends with the given amount of line breaks
+	newline := func(count int) {
+		space = false
+		for len(result) > 0 && result[len(result)-1] == ' ' {
+			result = result[:len(result)-1]
+		}
+
+		for index := len(result) - 1; index >= 0 && result[index] == '\n'; index-- {
+			count--
+		}
+
+		for ; count > 0; count-- {
+			result = append(result, '\n')
+		}
+	}
+
+	for len(content) > 0 {
+		start := strings.IndexByte(content, '<')
+		if start < 0 {
+			start = len(content)
+		}
+
+		if skip == "" {
+			write(html.UnescapeString(content[:start]))
+		}
+
+		content = content[start:]
+		if content == "" {
+			break
+		}
+
+		if strings.HasPrefix(content, "<!--") {
+			end := strings.Index(content, "-->")
+			if end < 0 {
+				break
+			}
+
+			content = content[end+len("-->"):]
+			continue
+		}
+
+		end := strings.IndexByte(content, '>')
+		if end < 0 {
+			break
+		}
+
+		tag := content[1:end]
+		content = content[end+1:]
+
+		closing := strings.HasPrefix(tag, "/")
+		name := strings.ToLower(strings.TrimLeft(tag, "/"))
+		if index := strings.IndexAny(name, " \t\r\n/"); index >= 0 {
+			name = name[:index]
+		}
+
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+
+			continue
+		}
+
+		switch name {
+		case "script", "style", "head", "title":
+			if !closing {
+				skip = name
+			}
+		case "br":
+			newline(0)
+			result = append(result, '\n')
+		case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "table", "blockquote", "pre", "hr":
+			newline(2)
+		case "tr":
+			newline(1)
+		case "li":
+			newline(1)
+			if !closing {
+				result = append(result, "- "...)
+			}
+		case "td", "th":
+			if closing {
+				space = true
+			}
+		case "a":
+			if !closing {
+				href := ""
+				if match := hrefAttribute.FindStringSubmatch(tag); match != nil {
+					href = html.UnescapeString(match[1] + match[2] + match[3])
+				}
+
+				links = append(links, len(result))
+				hrefs = append(hrefs, href)
+				continue
+			}
+
+			if len(links) == 0 {
+				continue
+			}
+
+			start, href := links[len(links)-1], hrefs[len(hrefs)-1]
+			links, hrefs = links[:len(links)-1], hrefs[:len(hrefs)-1]
+
+			text := strings.TrimSpace(string(result[start:]))
+			if href == "" || strings.HasPrefix(href, "#") || text == href || "mailto:"+text == href {
+				continue
+			}
+
+			write(" (" + href + ")")
+		}
+	}
+
+	// Trailing whitespace is trimmed and consecutive empty lines are collapsed
+	lines := strings.Split(string(result), "\n")
+	output := make([]string, 0, len(lines))
+	empty := true
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if empty {
+				continue
+			}
+
+			empty = true
+			output = append(output, line)
+			continue
+		}
+
+		empty = false
+		output = append(output, line)
+	}
+
+	return strings.TrimSpace(strings.Join(output, "\n"))
+}
+
+// NewHTMLAlternative constructs a quoted-printable text/html part and a
+// text/plain alternative generated from the HTML content using
+// GenerateTextFromHTML.
+func NewHTMLAlternative(html string) []*Part {
+	return NewAlternative(GenerateTextFromHTML(html), html)
+}
@@ -0,0 +1,57 @@
+package postbox
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGenerateTextFromHTML test if tags are stripped and links are preserved
+func TestGenerateTextFromHTML(t *testing.T) {
+	content := `<html>
+	<head><title>Newsletter</title><style>p { color: red; }</style></head>
+	<body>
+		<h1>Hello   world</h1>
+		<!-- tracking comment -->
+		<p>Read the <a href="https://example.com/post?id=1&amp;ref=mail">latest post</a>
+		or visit <a href='https://example.com'>https://example.com</a>.<br>Thanks &amp; regards</p>
+		<ul><li>first</li><li><b>second</b></li></ul>
+		<script>alert("hello")</script>
+	</body>
+</html>`
+
+	expected := "Hello world\n" +
+		"\n" +
+		"Read the latest post (https://example.com/post?id=1&ref=mail) or visit https://example.com.\n" +
+		"Thanks & regards\n" +
+		"\n" +
+		"- first\n" +
+		"- second"
+
+	result := GenerateTextFromHTML(content)
+	if result != expected {
+		t.Fatalf("Unexpected text: %q", result)
+	}
+}
+
+// TestNewHTMLAlternative test if a plain text alternative is generated from the html part
+func TestNewHTMLAlternative(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: NewHTMLAlternative("<p>hello <b>world</b></p>"),
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := structure(t, output)
+	if result != "multipart/alternative(text/plain,text/html)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	if !strings.Contains(output, CRLF+"hello world"+CRLF) {
+		t.Fatal("Generated text not found:", output)
+	}
+}
@@ -1,12 +1,19 @@
 package postbox
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime/quotedprintable"
+	"net/url"
+	"os"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,8 +29,27 @@ const (
 	// Unencoded can be used to avoid encoding the body of an email. The headers
 	// will still be encoded using quoted-printable encoding.
 	Unencoded Encoding = "8bit"
+	// SevenBit represents strictly ASCII content with lines no longer than 998
+	// characters. The body is written as is.
+	SevenBit Encoding = "7bit"
+	// Binary represents unrestricted content which should only be used with
+	// servers supporting the BINARYMIME extension. The body is written as is.
+	Binary Encoding = "binary"
 )
 
+// ErrUnknownEncoding is returned when an unknown transfer encoding is used
+var ErrUnknownEncoding = errors.New("unknown content transfer encoding")
+
+// Validate checks whether the encoding is a known transfer encoding
+func (e Encoding) Validate() error {
+	switch e {
+	case QuotedPrintable, Base64, Unencoded, SevenBit, Binary:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q", ErrUnknownEncoding, string(e))
+}
+
 // CR represents a ASCII CR
 const CR = "\r"
 
@@ -33,71 +59,710 @@ const LF = "\n"
 // CRLF represents a ASCII CR+LF
 const CRLF = CR + LF
 
+// MaxLineLength represents the maximum line length of encoded content as
+// defined in RFC 2045.
+const MaxLineLength = 76
+
+// lineLength returns the given base64 line length, MaxLineLength is returned
+// when no length is given
+func lineLength(length int) int {
+	if length <= 0 {
+		return MaxLineLength
+	}
+
+	return length
+}
+
 // ContentType and it's boundry
 type ContentType string
 
+// HeaderOrder represents the order in which well known headers are written
+// as recommended in RFC 5322. Headers not mentioned are written afterwards in
+// alphabetical order.
+var HeaderOrder = []string{
+	"Return-Path",
+	"Received",
+	"Date",
+	"From",
+	"Sender",
+	"Reply-To",
+	"To",
+	"Cc",
+	"Subject",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+}
+
+// RepeatedHeaders represents the headers which could occur multiple times
+// (ex: trace fields). Every value of a repeated header is written as separate
+// header line instead of being joined with "; ".
+var RepeatedHeaders = []string{
+	"Received",
+	"DKIM-Signature",
+	"ARC-Seal",
+	"ARC-Message-Signature",
+	"ARC-Authentication-Results",
+	"Authentication-Results",
+	"Comments",
+	"Keywords",
+}
+
+// AddressHeaders represents the headers containing address lists. Multiple
+// values of address headers are joined with ", " as defined in RFC 5322
+// section 3.4.
+var AddressHeaders = []string{
+	"From",
+	"Sender",
+	"Reply-To",
+	"To",
+	"Cc",
+	"Bcc",
+	"Resent-From",
+	"Resent-Sender",
+	"Resent-To",
+	"Resent-Cc",
+	"Resent-Bcc",
+}
+
 // Headers is a representation of a multiform part header
 type Headers map[string][]string
 
-// Write writes the headers to the given io.Writer
-func (h Headers) Write(writer io.Writer) {
-	for property, values := range h {
-		writer.Write([]byte(property))
+// Keys returns the header keys in a deterministic order. Well known headers
+// are returned in the order defined in HeaderOrder.
+func (h Headers) Keys() []string {
+	keys := make([]string, 0, len(h))
+
+	for _, property := range HeaderOrder {
+		if _, has := h[property]; has {
+			keys = append(keys, property)
+		}
+	}
+
+	offset := len(keys)
+
+	for property := range h {
+		if !ordered(property) {
+			keys = append(keys, property)
+		}
+	}
+
+	sort.Strings(keys[offset:])
+	return keys
+}
+
+// ordered checks whether the given header property is defined in HeaderOrder
+func ordered(property string) bool {
+	for _, key := range HeaderOrder {
+		if key == property {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Write writes the headers to the given io.Writer. Multiple values are joined
+// with "; " (ex: Content-Type parameters), or ", " for headers defined in
+// AddressHeaders, unless the header is defined in RepeatedHeaders. Long header
+// values are folded onto continuation lines. An error is returned and nothing
+// is written when a header name or value is invalid, preventing header
+// injection through embedded CR/LF characters.
+func (h Headers) Write(writer io.Writer) error {
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+
+	h = h.canonical()
+	sticky := &stickyWriter{writer: writer}
+
+	for _, property := range h.Keys() {
+		values := h[property]
 
 		if len(values) == 0 {
-			writer.Write([]byte(":" + CRLF))
+			sticky.Write([]byte(property + ":" + CRLF))
 			continue
 		}
 
-		writer.Write([]byte(": "))
+		if !includes(RepeatedHeaders, property) {
+			separator := "; "
+			if includes(AddressHeaders, property) {
+				separator = ", "
+			}
+
+			values = []string{strings.Join(values, separator)}
+		}
+
+		for _, value := range values {
+			sticky.Write([]byte(property + ": "))
+			io.WriteString(sticky, fold(value, len(property)+2))
+			sticky.Write(crlf)
+		}
+	}
+
+	return sticky.err
+}
+
+// includes checks whether the given header property is included inside the
+// given header names
+func includes(names []string, property string) bool {
+	for _, key := range names {
+		if strings.EqualFold(key, property) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canonical returns a copy of the headers with canonical header names. Values
+// of headers only differing in casing are overridden in alphabetical order.
+func (h Headers) canonical() Headers {
+	keys := make([]string, 0, len(h))
+	for property := range h {
+		keys = append(keys, property)
+	}
+
+	sort.Strings(keys)
+
+	result := make(Headers, len(h))
+	for _, property := range keys {
+		result[canonicalKey(property)] = h[property]
+	}
+
+	return result
+}
 
-		values := strings.Join(values, "; ")
-		reader := strings.NewReader(values)
+// Validate checks whether all header names and values are valid
+func (h Headers) Validate() error {
+	for property, values := range h {
+		err := validateHeaderName(property)
+		if err != nil {
+			return err
+		}
 
-		io.Copy(writer, reader)
-		writer.Write([]byte(CRLF))
+		for _, value := range values {
+			err := validateHeaderValue(property, value)
+			if err != nil {
+				return err
+			}
+		}
 	}
+
+	return nil
 }
 
+// Disposition represents the presentation style of a part as defined in
+// RFC 2183.
+type Disposition string
+
+const (
+	// Inline represents content which should be displayed automatically
+	Inline Disposition = "inline"
+	// Attachment represents content which should only be displayed on request
+	Attachment Disposition = "attachment"
+)
+
+// DefaultCharset represents the charset used when no charset has been set
+const DefaultCharset = "utf-8"
+
 // Part represents a multiform part
 type Part struct {
 	ContentType string
 	Encoding    Encoding
 	Reader      io.Reader
+	ReaderFunc  func() io.Reader // constructs a new reader on every write, preferred over Reader
+	Charset     string           // overrides the given (envelope) charset when set
+
+	// Disposition is written as Content-Disposition header when set. The
+	// optional filename is included as disposition parameter.
+	Disposition Disposition
+	Filename    string
+
+	// Transcoder converts the UTF-8 content of the reader into the declared
+	// charset when set. An error is returned when the content could not be
+	// represented inside the declared charset.
+	Transcoder Transcoder
+
+	// Headers contains additional part headers (ex: Content-Language). The
+	// Content-Type and Content-Transfer-Encoding headers take precedence.
+	Headers Headers
+
+	// LongLines defines the handling of lines exceeding MaxBodyLineLength
+	// inside 7bit and 8bit encoded parts, RejectLongLines is used by default
+	LongLines LongLines
+
+	// LineLength is used as line length of base64 encoded content (ex: 64 for
+	// legacy gateways), MaxLineLength is used when left empty
+	LineLength int
+
+	// Description is written as Content-Description header when set, non-ASCII
+	// descriptions are encoded as RFC 2047 encoded-words
+	Description string
+
+	// Language is written as Content-Language header (RFC 3282) when set (ex:
+	// en, de-CH). Multiple comma separated language tags could be given.
+	Language string
+
+	// ValidateUTF8 validates the content of text parts declared as utf-8 while
+	// it is being written. ErrInvalidUTF8 is returned, including the byte
+	// offset of the first invalid sequence, when invalid content is written.
+	ValidateUTF8 bool
 }
 
-// Write writes the part to the given io writer
-func (p *Part) Write(writer io.Writer, charset string) {
-	headers := Headers{
-		"Content-Type":              {p.ContentType, "charset=" + charset},
-		"Content-Transfer-Encoding": {string(p.Encoding)},
+// MaxBodyLineLength represents the maximum length of a line (excluding the
+// CRLF) as defined in RFC 5322 section 2.1.1. Longer lines could be truncated
+// by mail transfer agents.
+const MaxBodyLineLength = 998
+
+// ErrLineTooLong is returned when a line of a 7bit or 8bit encoded part
+// exceeds MaxBodyLineLength
+var ErrLineTooLong = errors.New("line exceeds 998 characters")
+
+// LongLines represents the handling of lines exceeding MaxBodyLineLength
+// inside 7bit and 8bit encoded parts
+type LongLines int
+
+const (
+	// RejectLongLines returns ErrLineTooLong once a line exceeding the limit
+	// is written
+	RejectLongLines LongLines = iota
+	// EncodeLongLines writes the part as quoted-printable when a line exceeds
+	// the limit. The content is buffered in memory in order to detect long
+	// lines before the part headers are written.
+	EncodeLongLines
+)
+
+// Write writes the part to the given io writer. The part charset is used when
+// set, otherwise the given charset or DefaultCharset. An error is returned when
+// the part encoding is not a known transfer encoding or when the content could
+// not be transcoded.
+func (p *Part) Write(writer io.Writer, charset string) error {
+	err := p.Encoding.Validate()
+	if err != nil {
+		return err
 	}
 
-	headers.Write(writer)
-	writer.Write([]byte(CRLF))
+	encoding := p.Encoding
+	transcoder := p.Transcoder
+	reader := p.reader()
+	if reader == nil {
+		return ErrNoReader
+	}
+
+	limited := encoding == SevenBit || encoding == Unencoded
+	if limited && p.LongLines == EncodeLongLines {
+		buffer := bytes.NewBuffer(nil)
+		err = transcode(buffer, transcoder, reader)
+		if err != nil {
+			return err
+		}
+
+		if exceeds(buffer.Bytes(), MaxBodyLineLength) {
+			encoding = QuotedPrintable
+		}
+
+		limited = false
+		transcoder = nil
+		reader = buffer
+	}
+
+	if p.Charset != "" {
+		charset = p.Charset
+	}
+
+	if charset == "" {
+		charset = DefaultCharset
+	}
+
+	if p.ValidateUTF8 && transcoder == nil && textual(p.ContentType) && strings.EqualFold(charset, "utf-8") {
+		transcoder = validUTF8{}
+	}
+
+	headers := Headers{}
+	for property, values := range p.Headers {
+		// MIME-Version is only allowed inside the message headers
+		if strings.EqualFold(property, "MIME-Version") {
+			continue
+		}
+
+		headers[canonicalKey(property)] = values
+	}
+
+	headers["Content-Type"] = []string{p.ContentType, "charset=" + charset}
+	headers["Content-Transfer-Encoding"] = []string{string(encoding)}
+
+	if p.Description != "" {
+		headers["Content-Description"] = []string{encodeHeader(p.Description)}
+	}
+
+	if p.Language != "" {
+		err = ValidateLanguage(p.Language)
+		if err != nil {
+			return err
+		}
+
+		headers["Content-Language"] = []string{p.Language}
+	}
+
+	if p.Disposition != "" {
+		values := []string{string(p.Disposition)}
+		if p.Filename != "" {
+			values = append(values, encodeParam("filename", p.Filename)...)
+		}
+
+		headers["Content-Disposition"] = values
+	}
+
+	err = headers.Write(writer)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(crlf)
+	if err != nil {
+		return err
+	}
+
+	encoder := encode(writer, encoding, p.LineLength)
+	if limited {
+		encoder = &lineLimitWriter{WriteCloser: encoder, limit: MaxBodyLineLength}
+	}
+
+	err = transcode(encoder, transcoder, reader)
+	closed := encoder.Close()
+	if err == nil {
+		err = closed
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(crlf)
+	return err
+}
+
+// textual checks whether the given content type represents a text type
+func textual(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "text/")
+}
+
+// exceeds checks whether the given content contains a line longer than the
+// given limit
+func exceeds(content []byte, limit int) bool {
+	for len(content) > 0 {
+		line := content
+		if index := bytes.IndexByte(content, '\n'); index >= 0 {
+			line = content[:index]
+			content = content[index+1:]
+		} else {
+			content = nil
+		}
+
+		if len(bytes.TrimSuffix(line, []byte(CR))) > limit {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lineLimitWriter returns ErrLineTooLong once a line longer than the given
+// limit is written
+type lineLimitWriter struct {
+	io.WriteCloser
+	limit  int
+	length int
+}
+
+func (l *lineLimitWriter) Write(p []byte) (int, error) {
+	for _, char := range p {
+		if char == '\n' {
+			l.length = 0
+			continue
+		}
+
+		if char != '\r' {
+			l.length++
+		}
+
+		if l.length > l.limit {
+			return 0, ErrLineTooLong
+		}
+	}
 
-	switch p.Encoding {
+	return l.WriteCloser.Write(p)
+}
+
+// reader returns the reader of the part content. The ReaderFunc is preferred
+// over the Reader when set.
+func (p *Part) reader() io.Reader {
+	if p.ReaderFunc != nil {
+		return p.ReaderFunc()
+	}
+
+	return p.Reader
+}
+
+// encode returns a writer encoding the written content using the given
+// transfer encoding. Base64 encoded content is wrapped at the given line
+// length. The returned writer has to be closed to flush any remaining content.
+func encode(writer io.Writer, encoding Encoding, length int) io.WriteCloser {
+	switch encoding {
 	case QuotedPrintable:
-		reader := quotedprintable.NewReader(p.Reader)
-		io.Copy(writer, reader)
+		return quotedprintable.NewWriter(writer)
 	case Base64:
-		encoder := base64.NewEncoder(base64.StdEncoding, writer)
-		io.Copy(encoder, p.Reader)
-		encoder.Close()
+		return newBase64Writer(writer, lineLength(length))
 	default:
-		io.Copy(writer, p.Reader)
+		return nopCloser{writer}
 	}
+}
+
+// nopCloser wraps the given io.Writer with a no-op Close method
+type nopCloser struct {
+	io.Writer
+}
 
-	writer.Write([]byte(CRLF))
+func (nopCloser) Close() error {
+	return nil
 }
 
+// ErrNoReader is returned when writing a part or raw part without a Reader or
+// ReaderFunc
+var ErrNoReader = errors.New("part has no reader")
+
+// ErrNoCopyFunc is returned when a file without a CopyFunc is written
+var ErrNoCopyFunc = errors.New("file has no copy func")
+
 // File represents a multiform file
 type File struct {
 	Name     string
-	Header   map[string][]string
 	CopyFunc func(w io.Writer) error
+
+	// Header contains additional file headers (ex: Content-Type). The
+	// Content-Transfer-Encoding header is derived from the Encoding and
+	// takes precedence.
+	Header map[string][]string
+
+	// Compress gzip compresses the content while it is being written. The
+	// file is written as application/gzip and the file name is suffixed
+	// with .gz when set.
+	Compress bool
+
+	// Size contains the size of the file content in bytes when known. The size
+	// is used to estimate the message size without reading the content.
+	Size int64
+
+	// ContentID is used to reference inline files (ex: <img src="cid:logo">).
+	// The file name is used when left empty.
+	ContentID string
+
+	// LineLength is used as line length of the base64 encoded content,
+	// MaxLineLength is used when left empty
+	LineLength int
+
+	// Description is written as Content-Description header when set (ex:
+	// Monthly invoice), non-ASCII descriptions are encoded as RFC 2047
+	// encoded-words
+	Description string
+
+	// Encoding is used as transfer encoding of the file content (ex:
+	// QuotedPrintable for text attachments), Base64 is used when left empty.
+	// Lines of 7bit and 8bit encoded files may not exceed MaxBodyLineLength.
+	Encoding Encoding
+
+	once bool // the CopyFunc consumes a reader and could only be called once
+}
+
+// encoding returns the transfer encoding of the file content
+func (f *File) encoding() Encoding {
+	if f.Encoding == "" {
+		return Base64
+	}
+
+	return f.Encoding
+}
+
+// ID returns the content identifier of the file without angle brackets. The
+// ContentID is returned when set, the file name otherwise. Characters of the
+// file name which are not allowed inside a Content-ID (ex: spaces or
+// non-ASCII characters) are percent-encoded (ex: my%20l%C3%B6go.png).
+func (f *File) ID() string {
+	if f.ContentID != "" {
+		return strings.TrimSuffix(strings.TrimPrefix(f.ContentID, "<"), ">")
+	}
+
+	return escapeID(f.Name)
+}
+
+// escapeID percent-encodes all characters of the given value which are not
+// allowed inside the atoms of a Content-ID. The percent sign itself is encoded
+// as well, keeping the encoding unambiguous.
+func escapeID(value string) string {
+	result := strings.Builder{}
+	for index := 0; index < len(value); index++ {
+		char := value[index]
+		if char == '.' || (char != '%' && atext(char)) {
+			result.WriteByte(char)
+			continue
+		}
+
+		fmt.Fprintf(&result, "%%%02X", char)
+	}
+
+	return result.String()
+}
+
+// atext checks whether the given character is allowed inside an atom as
+// defined in RFC 5322 3.2.3
+func atext(char byte) bool {
+	switch {
+	case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9':
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", char) >= 0
+}
+
+// URL returns the cid URL (ex: cid:logo.png) as defined in RFC 2392 which
+// could be used to reference the inline file from a HTML part. Characters of
+// the identifier which are not allowed inside a URL are percent-encoded.
+func (f *File) URL() string {
+	return "cid:" + url.PathEscape(f.ID())
+}
+
+// Write writes the file as an encoded part (base64 by default) to the given
+// io.Writer. The given disposition (ex: attachment) is written as
+// Content-Disposition header including the file name as filename parameter
+// when set, non-ASCII names are encoded as defined in RFC 2231. Inline files
+// receive a Content-ID allowing them to be referenced (ex: cid:logo.png), a
+// random identifier is generated when the file has no ContentID or name.
+// Headers set inside the file header override the default part headers.
+func (f *File) Write(writer io.Writer, disposition Disposition) error {
+	if f.CopyFunc == nil {
+		return fmt.Errorf("%w: %q", ErrNoCopyFunc, f.Name)
+	}
+
+	err := f.encoding().Validate()
+	if err != nil {
+		return err
+	}
+
+	name := f.Name
+	if f.Compress && name != "" {
+		name += ".gz"
+	}
+
+	headers := Headers{
+		"Content-Type":        {DefaultContentType},
+		"Content-Disposition": {string(disposition)},
+	}
+
+	if name != "" {
+		headers["Content-Disposition"] = append(headers["Content-Disposition"], encodeParam("filename", name)...)
+	}
+
+	if f.Description != "" {
+		headers["Content-Description"] = []string{encodeHeader(f.Description)}
+	}
+
+	if disposition == Inline {
+		if f.ID() == "" {
+			id, err := random(16)
+			if err != nil {
+				return err
+			}
+
+			f.ContentID = id
+		}
+
+		headers["Content-ID"] = []string{"<" + f.ID() + ">"}
+	}
+
+	for property, values := range f.Header {
+		// MIME-Version is only allowed inside the message headers
+		if strings.EqualFold(property, "MIME-Version") {
+			continue
+		}
+
+		headers[canonicalKey(property)] = values
+	}
+
+	// The content is always encoded using the file encoding
+	headers["Content-Transfer-Encoding"] = []string{string(f.encoding())}
+
+	if f.Compress {
+		headers["Content-Type"] = []string{"application/gzip"}
+	}
+
+	err = headers.Write(writer)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(crlf)
+	if err != nil {
+		return err
+	}
+
+	err = f.encode(writer)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(crlf)
+	return err
+}
+
+// encode writes the encoded file content to the given writer. The encoder is
+// always closed, flushing the content written before a failure of the
+// CopyFunc. The CopyFunc error takes precedence over the close error.
+func (f *File) encode(writer io.Writer) (err error) {
+	encoding := f.encoding()
+	encoder := encode(writer, encoding, f.LineLength)
+	if encoding == SevenBit || encoding == Unencoded {
+		encoder = &lineLimitWriter{WriteCloser: encoder, limit: MaxBodyLineLength}
+	}
+
+	defer func() {
+		closed := encoder.Close()
+		if err == nil {
+			err = closed
+		}
+	}()
+
+	return f.copy(encoder)
+}
+
+// copy copies the file content to the given writer. The content is gzip
+// compressed on the fly when set, the compressor is always closed.
+func (f *File) copy(writer io.Writer) (err error) {
+	if !f.Compress {
+		return f.CopyFunc(writer)
+	}
+
+	compressor := gzip.NewWriter(writer)
+	defer func() {
+		closed := compressor.Close()
+		if err == nil {
+			err = closed
+		}
+	}()
+
+	return f.CopyFunc(compressor)
+}
+
+// quote returns the given value as a quoted string, escaping backslashes and
+// double quotes.
+func quote(value string) string {
+	return `"` + quoter.Replace(value) + `"`
 }
 
+// quoter escapes backslashes and double quotes inside quoted strings
+var quoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
 // Boundary represents a multipart boundary
 type Boundary struct {
 	Identifier string
@@ -105,11 +770,19 @@ type Boundary struct {
 }
 
 // NewBoundary starts a new multipart context and generates a new boundary.
-// The headers are written to the given io.Writer.
-func NewBoundary(writer io.Writer, mime string) Boundary {
-	identifier := RandomBoundary()
+// The headers are written to the given io.Writer. Additional Content-Type
+// parameters (ex: type="multipart/alternative") could be given.
+func NewBoundary(writer io.Writer, mime string, params ...string) Boundary {
+	return StartBoundary(writer, RandomBoundary(), mime, params...)
+}
+
+// StartBoundary starts a new multipart context using the given boundary
+// identifier. The headers are written to the given io.Writer. The boundary
+// parameter is quoted when the identifier contains characters outside the
+// token set (ex: spaces).
+func StartBoundary(writer io.Writer, identifier string, mime string, params ...string) Boundary {
 	headers := Headers{
-		"Content-Type": {mime, "boundary=" + identifier},
+		"Content-Type": append([]string{mime, boundaryParam(identifier)}, params...),
 	}
 
 	boundary := Boundary{
@@ -118,84 +791,971 @@ func NewBoundary(writer io.Writer, mime string) Boundary {
 	}
 
 	headers.Write(writer)
-	writer.Write([]byte(CRLF))
+	writer.Write(crlf)
 
 	return boundary
 }
 
+// boundaryParam returns the boundary parameter of the given identifier. The
+// identifier is written as quoted string when it contains characters which
+// are not allowed inside a token (ex: spaces).
+func boundaryParam(identifier string) string {
+	if token(identifier) {
+		return "boundary=" + identifier
+	}
+
+	return "boundary=" + quote(identifier)
+}
+
 // Mark appends the boundary identifier to the set io.Writer
 func (b *Boundary) Mark() {
 	b.writer.Write([]byte("--" + b.Identifier + CRLF))
 }
 
+// Nest marks the start of a new part and starts a nested multipart context
+// inside of it. The nested boundary has to be ended before the parent boundary
+// is marked or ended again.
+func (b *Boundary) Nest(mime string, params ...string) Boundary {
+	b.Mark()
+	return NewBoundary(b.writer, mime, params...)
+}
+
 // End marks the boundary as ended
 func (b *Boundary) End() {
-	b.writer.Write([]byte("--" + b.Identifier + "--" + CRLF + CRLF))
+	b.writer.Write([]byte("--" + b.Identifier + "--" + CRLF))
 }
 
-// Envelope is responsible for the generation of RFC 822-style emails.
-// Specifications mentioned:
-// - RFC 2822 - Internet Message Format
-// - RFC 2387 - The MIME Multipart/Related Content-type
-// - RFC 1341 - MIME  (Multipurpose Internet Mail Extensions)
-// - RFC 4021 - Registration of Mail and MIME Header Fields
-type Envelope struct {
-	Date        time.Time // RFC 4021 2.1.1
-	From        string    // RFC 4021 2.1.2
-	Sender      string    // RFC 4021 2.1.3
-	ReplyTo     string    // RFC 4021 2.1.4
-	To          []string  // RFC 4021 2.1.5
-	Cc          []string  // RFC 4021 2.1.6
-	Subject     string    // RFC 4021 2.1.11
-	Parts       []*Part   // RFC 1341 7.2
-	Embedded    []*File   // RFC 2387
-	Attachments []*File   // RFC 1341 7.2
-	Charset     string
-}
+var (
+	// ErrEmptyMessage is returned when writing an envelope without any parts,
+	// embedded files or attachments
+	ErrEmptyMessage = errors.New("message has no parts or attachments")
+	// ErrNoFrom is returned when writing an envelope without a From address
+	ErrNoFrom = errors.New("message has no from address")
+	// ErrNoSender is returned when writing an envelope with multiple From
+	// addresses without a Sender
+	ErrNoSender = errors.New("message has multiple from addresses but no sender")
+	// ErrNoRecipients is returned when writing an envelope without any To, Cc
+	// or Bcc recipients
+	ErrNoRecipients = errors.New("message has no recipients")
+)
 
-// Write writes the smtp message as multiform to the given io.Writer
-func (e *Envelope) Write(writer io.WriteCloser) {
-	if e.Date.IsZero() {
-		e.Date = time.Now()
+// Priority represents the priority of a message
+type Priority string
+
+const (
+	// LowPriority marks the message as low priority
+	LowPriority Priority = "low"
+	// NormalPriority represents the default priority, no headers are written
+	NormalPriority Priority = "normal"
+	// HighPriority marks the message as high priority
+	HighPriority Priority = "high"
+)
+
+// headers returns the conventional priority headers (X-Priority, Importance
+// and X-MSMail-Priority). No headers are returned for the normal priority.
+func (p Priority) headers() Headers {
+	switch p {
+	case LowPriority:
+		return Headers{
+			"X-Priority":        {"5"},
+			"Importance":        {"low"},
+			"X-MSMail-Priority": {"Low"},
+		}
+	case HighPriority:
+		return Headers{
+			"X-Priority":        {"1"},
+			"Importance":        {"high"},
+			"X-MSMail-Priority": {"High"},
+		}
+	}
+
+	return nil
+}
+
+// AutoSubmitted represents the Auto-Submitted header value as defined in
+// RFC 3834 section 5
+type AutoSubmitted string
+
+const (
+	// NotAutoSubmitted marks the message as explicitly written by a person
+	NotAutoSubmitted AutoSubmitted = "no"
+	// AutoGenerated marks the message as generated by an automatic process
+	// (ex: transactional mail) preventing auto responders from replying
+	AutoGenerated AutoSubmitted = "auto-generated"
+	// AutoReplied marks the message as automatic response to another message
+	// (ex: vacation notices)
+	AutoReplied AutoSubmitted = "auto-replied"
+)
+
+// Sensitivity represents the Sensitivity header value as defined in RFC 2156
+// section 5.3.4
+type Sensitivity string
+
+const (
+	// NormalSensitivity represents the default sensitivity, no header is
+	// written
+	NormalSensitivity Sensitivity = "Normal"
+	// PersonalSensitivity marks the message as personal
+	PersonalSensitivity Sensitivity = "Personal"
+	// PrivateSensitivity marks the message as private
+	PrivateSensitivity Sensitivity = "Private"
+	// ConfidentialSensitivity marks the message as company confidential
+	ConfidentialSensitivity Sensitivity = "Company-Confidential"
+)
+
+// RFC5322Date represents the date-time layout as defined in RFC 5322 section
+// 3.3. The day of the week is included and the numeric zone is always written.
+const RFC5322Date = "Mon, 02 Jan 2006 15:04:05 -0700"
+
+// Envelope is responsible for the generation of RFC 822-style emails.
+// Specifications mentioned:
+// - RFC 2822 - Internet Message Format
+// - RFC 2387 - The MIME Multipart/Related Content-type
+// - RFC 1341 - MIME  (Multipurpose Internet Mail Extensions)
+// - RFC 4021 - Registration of Mail and MIME Header Fields
+type Envelope struct {
+	Date        time.Time  // RFC 4021 2.1.1
+	From        string     // RFC 4021 2.1.2, multiple comma separated addresses require a Sender
+	FromAddress Address    // preferred over From when an email is set
+	Sender      string     // RFC 4021 2.1.3
+	ReplyTo     []string   // RFC 4021 2.1.4
+	To          []string   // RFC 4021 2.1.5
+	Cc          []string   // RFC 4021 2.1.6
+	Bcc         []string   // RFC 4021 2.1.7, never written to the message headers
+	MessageID   string     // RFC 4021 2.1.8, generated when empty
+	InReplyTo   string     // RFC 4021 2.1.9
+	References  []string   // RFC 4021 2.1.10
+	Subject     string     // RFC 4021 2.1.11
+	Parts       []*Part    // RFC 1341 7.2
+	Embedded    []*File    // RFC 2387
+	Attachments []*File    // RFC 1341 7.2
+	RawParts    []*RawPart // written as is after the attachments
+	Charset     string
+	Priority    Priority // written as X-Priority, Importance and X-MSMail-Priority
+
+	// AutoSubmitted is written as Auto-Submitted header (RFC 3834) when set
+	AutoSubmitted AutoSubmitted
+
+	// Sensitivity is written as Sensitivity header when set and not normal
+	Sensitivity Sensitivity
+
+	// Headers contains additional message headers (ex: List-Unsubscribe,
+	// X-Mailer). Custom headers override the well known headers. Content
+	// headers (ex: Content-Type) are written by the parts, files and
+	// boundaries of the message, ErrContentHeader is returned when set.
+	Headers Headers
+
+	// BoundaryFunc is used to generate multipart boundaries. RandomBoundary is
+	// used when left empty. A deterministic generator could be used to render
+	// reproducible messages. The written content of all parts is checked for
+	// lines starting with the delimiter of an enclosing boundary,
+	// ErrBoundaryCollision is returned when found.
+	BoundaryFunc func() string
+
+	// Clock is used to retrieve the current time written as Date header when
+	// no Date is set, time.Now is used when left empty. A fixed clock could
+	// be used to render reproducible messages.
+	Clock func() time.Time
+
+	// ReturnPath is used as bounce address (SMTP MAIL FROM) when set. The
+	// Return-Path header is normally added by the receiving server and is
+	// therefore only written when EmitReturnPath is set.
+	ReturnPath     string
+	EmitReturnPath bool
+
+	// Unsubscribe contains the mailto: and/or https: URLs written to the
+	// List-Unsubscribe header (RFC 2369). The List-Unsubscribe-Post header
+	// enabling one-click unsubscription (RFC 8058) is written when a https:
+	// URL is included.
+	Unsubscribe []string
+
+	// Force7Bit ensures the message could be transmitted to servers not
+	// supporting 8BITMIME. Parts declared as 8bit or binary are base64
+	// encoded when set.
+	Force7Bit bool
+
+	// DateFormat is used as layout of the Date header, RFC5322Date is used when
+	// left empty. The date is rendered in DateLocation when set.
+	DateFormat   string
+	DateLocation *time.Location
+
+	// MessageIDDomain is used as the domain of generated message identifiers.
+	// The domain of the From address is used when left empty.
+	MessageIDDomain string
+
+	// ReportType writes the parts as multipart/report (RFC 6522) instead of
+	// multipart/alternative using the given report type (ex:
+	// DeliveryStatusReport). The first part should contain a human readable
+	// explanation followed by the machine readable report (ex:
+	// NewDeliveryStatusPart) and optionally the (headers of the) original
+	// message.
+	ReportType string
+
+	// LineEnding is used as line break of the rendered message (ex: LF when
+	// writing .eml files for local tools), CRLF is used when left empty.
+	// Messages transmitted over SMTP require CRLF line endings.
+	LineEnding string
+
+	// LineLength is used as line length of base64 encoded parts and files
+	// without a LineLength (ex: 64 for legacy gateways), MaxLineLength is used
+	// when left empty. Quoted-printable lines are always wrapped at
+	// MaxLineLength.
+	LineLength int
+
+	// ValidateUTF8 enables the UTF-8 validation of all text parts declared
+	// as utf-8 (see Part.ValidateUTF8)
+	ValidateUTF8 bool
+
+	// Language is used as Content-Language of all parts without a Language
+	// (see Part.Language)
+	Language string
+
+	generatedID string // the MessageID generated while writing the message
+}
+
+// Write writes the smtp message as multiform to the given io.Writer. Messages
+// containing a single part (ex: a text/plain body without attachments) are
+// written as flat message without multipart boundaries. The writer is not
+// closed once the message has been written.
+func (e *Envelope) Write(writer io.Writer) error {
+	if e.LineEnding == "" || e.LineEnding == CRLF {
+		return e.write(writer)
+	}
+
+	converter := &lineEndingWriter{writer: writer, ending: []byte(e.LineEnding)}
+	err := e.write(converter)
+	if err != nil {
+		return err
+	}
+
+	return converter.Flush()
+}
+
+// write writes the message to the given io.Writer using CRLF line endings
+func (e *Envelope) write(writer io.Writer) error {
+	err := e.required()
+	if err != nil {
+		return err
+	}
+
+	err = validateMessageHeaders(e.Headers)
+	if err != nil {
+		return err
+	}
+
+	if e.Date.IsZero() {
+		e.Date = e.now()
+	}
+
+	if e.Charset == "" {
+		e.Charset = DefaultCharset
+	}
+
+	if e.MessageID == "" {
+		id, err := GenerateMessageID(e.domain())
+		if err != nil {
+			return err
+		}
+
+		e.MessageID = id
+		e.generatedID = id
 	}
 
 	headers := Headers{
-		"Date":         {e.Date.Format(time.RFC1123Z)},
-		"From":         {e.From},
-		"To":           e.To,
-		"Cc":           e.Cc,
-		"Reply-To":     {e.ReplyTo},
-		"Subject":      {e.Subject},
-		"Mime-Version": {"1.0"},
+		"Date":         {e.date()},
+		"From":         {encodeAddressList(e.author())},
+		"Subject":      {encodeHeader(e.Subject)},
+		"Message-ID":   {msgID(e.MessageID)},
+		"MIME-Version": {"1.0"},
 	}
 
-	headers.Write(writer)
+	// Empty address headers are invalid and therefore omitted
+	if len(e.To) > 0 {
+		headers["To"] = encodeAddresses(e.To)
+	}
+
+	if len(e.Cc) > 0 {
+		headers["Cc"] = encodeAddresses(e.Cc)
+	}
+
+	if len(e.ReplyTo) > 0 {
+		headers["Reply-To"] = encodeAddresses(e.ReplyTo)
+	}
+
+	if e.Sender != "" {
+		headers["Sender"] = []string{encodeAddress(e.Sender)}
+	}
+
+	if e.EmitReturnPath && e.ReturnPath != "" {
+		headers["Return-Path"] = []string{msgID(e.ReturnPath)}
+	}
+
+	for property, values := range e.Priority.headers() {
+		headers[property] = values
+	}
+
+	if e.AutoSubmitted != "" {
+		headers["Auto-Submitted"] = []string{string(e.AutoSubmitted)}
+	}
+
+	if e.Sensitivity != "" && e.Sensitivity != NormalSensitivity {
+		headers["Sensitivity"] = []string{string(e.Sensitivity)}
+	}
+
+	if len(e.Unsubscribe) > 0 {
+		urls := make([]string, len(e.Unsubscribe))
+		for index, url := range e.Unsubscribe {
+			urls[index] = "<" + url + ">"
+
+			if strings.HasPrefix(strings.ToLower(url), "https:") {
+				headers["List-Unsubscribe-Post"] = []string{"List-Unsubscribe=One-Click"}
+			}
+		}
+
+		headers["List-Unsubscribe"] = []string{strings.Join(urls, ", ")}
+	}
+
+	if e.InReplyTo != "" {
+		headers["In-Reply-To"] = []string{msgID(e.InReplyTo)}
+	}
+
+	if len(e.References) > 0 {
+		references := make([]string, len(e.References))
+		for index, reference := range e.References {
+			references[index] = msgID(reference)
+		}
+
+		headers["References"] = []string{strings.Join(references, " ")}
+	}
+
+	for property, values := range e.Headers {
+		headers[canonicalKey(property)] = values
+	}
+
+	// Boundary delimiters are written without returning write errors, the
+	// first write error is recorded and returned once the message is written.
+	sticky := &stickyWriter{writer: writer}
+
+	err = headers.Write(sticky)
+	if err != nil {
+		return err
+	}
+
+	err = e.body().write(sticky)
+	if err != nil {
+		return err
+	}
+
+	return sticky.err
+}
+
+// required checks whether the fields required to write the message are set
+func (e *Envelope) required() error {
+	violations := e.requirements()
+	if len(violations) > 0 {
+		return violations[0]
+	}
+
+	return nil
+}
+
+// author returns the From address. The FromAddress is preferred over the From
+// field when set.
+func (e *Envelope) author() string {
+	if e.FromAddress.Email != "" {
+		return e.FromAddress.String()
+	}
+
+	return e.From
+}
+
+// requirements returns all missing required fields
+func (e *Envelope) requirements() []error {
+	violations := []error{}
+
+	if e.author() == "" {
+		violations = append(violations, ErrNoFrom)
+	}
+
+	if e.Sender == "" {
+		authors, err := ParseAddressList(e.author())
+		if err == nil && len(authors) > 1 {
+			violations = append(violations, ErrNoSender)
+		}
+	}
+
+	if len(e.To) == 0 && len(e.Cc) == 0 && len(e.Bcc) == 0 {
+		violations = append(violations, ErrNoRecipients)
+	}
+
+	if len(e.Parts) == 0 && len(e.Embedded) == 0 && len(e.Attachments) == 0 && len(e.RawParts) == 0 {
+		violations = append(violations, ErrEmptyMessage)
+	}
+
+	return violations
+}
+
+// AddTo appends the given addresses to the To recipients
+func (e *Envelope) AddTo(addresses ...string) {
+	e.To = append(e.To, addresses...)
+}
+
+// AddCc appends the given addresses to the Cc recipients
+func (e *Envelope) AddCc(addresses ...string) {
+	e.Cc = append(e.Cc, addresses...)
+}
+
+// AddBcc appends the given addresses to the Bcc recipients
+func (e *Envelope) AddBcc(addresses ...string) {
+	e.Bcc = append(e.Bcc, addresses...)
+}
+
+// AddAttachment appends the given file to the attachments
+func (e *Envelope) AddAttachment(file *File) {
+	e.Attachments = append(e.Attachments, file)
+}
+
+// now returns the current time of the envelope clock
+func (e *Envelope) now() time.Time {
+	if e.Clock != nil {
+		return e.Clock()
+	}
+
+	return time.Now()
+}
+
+// date returns the formatted Date header value
+func (e *Envelope) date() string {
+	date := e.Date
+	if e.DateLocation != nil {
+		date = date.In(e.DateLocation)
+	}
+
+	format := e.DateFormat
+	if format == "" {
+		format = RFC5322Date
+	}
+
+	return date.Format(format)
+}
+
+// entity represents a single MIME entity inside the message tree
+type entity struct {
+	media string
+	write func(writer io.Writer) error
+}
+
+// body constructs the MIME tree of the message. The mixed entity contains the
+// related content followed by the attachments and raw parts. The related
+// entity contains the alternative (or report) parts followed by the embedded
+// files. Multipart levels containing only a single entity are omitted.
+func (e *Envelope) body() *entity {
+	alternatives := make([]*entity, 0, len(e.Parts))
+	for index, part := range e.Parts {
+		alternatives = append(alternatives, e.part(index+1, part))
+	}
+
+	content := e.group("multipart/alternative", alternatives)
+	if e.ReportType != "" {
+		content = e.group("multipart/report", alternatives, "report-type="+e.ReportType)
+	}
+
+	related := make([]*entity, 0, len(e.Embedded)+1)
+	if content != nil {
+		related = append(related, content)
+	}
+
+	for index, embedded := range e.Embedded {
+		related = append(related, e.file(index+1, embedded, Inline))
+	}
+
+	params := []string{}
+	if content != nil {
+		params = append(params, "type="+quote(content.media))
+	}
+
+	content = e.group("multipart/related", related, params...)
+
+	mixed := make([]*entity, 0, len(e.Attachments)+len(e.RawParts)+1)
+	if content != nil {
+		mixed = append(mixed, content)
+	}
+
+	for index, attachment := range e.Attachments {
+		mixed = append(mixed, e.file(index+1, attachment, Attachment))
+	}
+
+	for index, raw := range e.RawParts {
+		mixed = append(mixed, e.raw(index+1, raw))
+	}
+
+	return e.group("multipart/mixed", mixed)
+}
+
+// part constructs a new entity writing the given part. Errors are wrapped with
+// the (1-based) position and content type of the part.
+func (e *Envelope) part(position int, original *Part) *entity {
+	// The envelope defaults are applied to a copy, the given part is kept as is
+	part := *original
+
+	if e.Force7Bit && (part.Encoding == Unencoded || part.Encoding == Binary) {
+		part.Encoding = Base64
+	}
+
+	if e.LineLength > 0 && part.LineLength == 0 {
+		part.LineLength = e.LineLength
+	}
+
+	if e.ValidateUTF8 {
+		part.ValidateUTF8 = true
+	}
+
+	if e.Language != "" && part.Language == "" {
+		part.Language = e.Language
+	}
+
+	return &entity{
+		media: part.ContentType,
+		write: func(writer io.Writer) error {
+			err := part.Write(writer, e.Charset)
+			if err != nil {
+				return fmt.Errorf("writing part %d (%s): %w", position, part.ContentType, err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// file constructs a new entity writing the given file with the given
+// disposition. Errors are wrapped with the (1-based) position and name of the
+// file.
+func (e *Envelope) file(position int, original *File, disposition Disposition) *entity {
+	// The envelope defaults are applied to a copy, the given file is kept as is
+	f := *original
+
+	if e.Force7Bit && (f.Encoding == Unencoded || f.Encoding == Binary) {
+		f.Encoding = Base64
+	}
+
+	if e.LineLength > 0 && f.LineLength == 0 {
+		f.LineLength = e.LineLength
+	}
+
+	media := DefaultContentType
+	if values := f.Header["Content-Type"]; len(values) > 0 {
+		media = values[0]
+	}
+
+	if f.Compress {
+		media = "application/gzip"
+	}
+
+	return &entity{
+		media: media,
+		write: func(writer io.Writer) error {
+			err := f.Write(writer, disposition)
+
+			// Generated Content-IDs are kept, allowing consecutive renders
+			// to reference the same identifier
+			original.ContentID = f.ContentID
+
+			if err != nil {
+				kind := "attachment"
+				if disposition == Inline {
+					kind = "embedded file"
+				}
+
+				return fmt.Errorf("writing %s %d (%s): %w", kind, position, f.Name, err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// group constructs a new multipart entity containing the given entities. A
+// single entity is returned as is and nil is returned when no entities are
+// given.
+func (e *Envelope) group(media string, entities []*entity, params ...string) *entity {
+	switch len(entities) {
+	case 0:
+		return nil
+	case 1:
+		return entities[0]
+	}
+
+	return &entity{
+		media: media,
+		write: func(writer io.Writer) error {
+			identifier, err := e.boundary()
+			if err != nil {
+				return err
+			}
+
+			boundary := StartBoundary(writer, identifier, media, params...)
+			nested := delimit(writer, identifier)
+
+			for _, entity := range entities {
+				boundary.Mark()
+
+				// The content of leaf entities is checked for delimiters of
+				// all enclosing boundaries
+				target := io.Writer(nested)
+				if !strings.HasPrefix(entity.media, "multipart/") {
+					target = guard(nested)
+				}
+
+				err = entity.write(target)
+				if err != nil {
+					return err
+				}
+			}
+
+			boundary.End()
+			return nil
+		},
+	}
+}
+
+// WriteContext writes the message to the given io.Writer. Writing is aborted
+// once the given context is done, in which case the context error is
+// returned. The writer is not closed once the message has been written.
+//
+// The context deadline is set as write deadline on writers supporting write
+// deadlines (ex: net.Conn), a blocked write is interrupted once the context
+// is done. Write deadlines set by the caller are kept when the context has no
+// deadline. A write deadline set by WriteContext is cleared once the message
+// has been written.
+func (e *Envelope) WriteContext(ctx context.Context, writer io.Writer) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	watched := false
+	if conn, ok := writer.(deadliner); ok && ctx.Done() != nil {
+		stop, err := watchDeadline(ctx, conn)
+		if err != nil && !errors.Is(err, os.ErrNoDeadline) {
+			return err
+		}
+
+		// Writers not supporting deadlines (ex: regular files) are written
+		// without being interrupted.
+		if err == nil {
+			watched = true
+			defer stop()
+		}
+	}
+
+	err = e.Write(&contextWriter{ctx: ctx, writer: writer})
+	if _, ok := ctx.Deadline(); ok && watched && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The write deadline could expire slightly before the context reports
+		// to be done, the context is awaited to return the context error.
+		<-ctx.Done()
+	}
+
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w: %s", ctx.Err(), err)
+	}
+
+	return err
+}
+
+// deadliner is implemented by writers supporting write deadlines (ex:
+// net.Conn)
+type deadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// watchDeadline sets the context deadline, when set, as write deadline of the
+// given connection and interrupts blocked writes once the context is done.
+// The returned function stops watching and clears the write deadline when it
+// has been set by the watcher, write deadlines set by the caller are kept.
+func watchDeadline(ctx context.Context, conn deadliner) (func(), error) {
+	deadline, modified := ctx.Deadline()
+	if modified {
+		err := conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			// A deadline in the past interrupts all pending writes
+			conn.SetWriteDeadline(time.Unix(1, 0))
+			modified = true
+		case <-done:
+		}
+	}()
 
-	mixed := NewBoundary(writer, "multipart/mixed")
-	mixed.Mark()
+	stop := func() {
+		close(done)
+		<-stopped
 
-	related := NewBoundary(writer, "multipart/related")
-	related.Mark()
+		if modified {
+			conn.SetWriteDeadline(time.Time{})
+		}
+	}
 
-	alternative := NewBoundary(writer, "multipart/alternative")
+	return stop, nil
+}
+
+// contextWriter returns the context error on write once the context is done
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
 
-	for _, part := range e.Parts {
-		alternative.Mark()
-		part.Write(writer, e.Charset)
+func (c *contextWriter) Write(p []byte) (int, error) {
+	err := c.ctx.Err()
+	if err != nil {
+		return 0, err
 	}
 
-	alternative.End()
-	related.End()
-	mixed.End()
-	writer.Close()
+	return c.writer.Write(p)
+}
+
+// lineEndingWriter replaces all CRLF line endings of the written content with
+// the given line ending. Bare CR characters are kept.
+type lineEndingWriter struct {
+	writer io.Writer
+	ending []byte
+	buffer []byte
+	cr     bool
 }
 
-// RandomBoundary generates a new random boundary
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	buffer := l.buffer[:0]
+
+	for _, char := range p {
+		if l.cr {
+			l.cr = false
+
+			if char == '\n' {
+				buffer = append(buffer, l.ending...)
+				continue
+			}
+
+			buffer = append(buffer, '\r')
+		}
+
+		if char == '\r' {
+			l.cr = true
+			continue
+		}
+
+		buffer = append(buffer, char)
+	}
+
+	l.buffer = buffer
+
+	_, err := l.writer.Write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the pending CR character when the content ended with a CR
+func (l *lineEndingWriter) Flush() error {
+	if !l.cr {
+		return nil
+	}
+
+	l.cr = false
+	_, err := l.writer.Write([]byte(CR))
+	return err
+}
+
+// WriteTo writes the message to the given io.Writer and returns the amount of
+// written bytes. The writer is not closed once the message has been written.
+func (e *Envelope) WriteTo(writer io.Writer) (int64, error) {
+	counter := &counter{writer: writer}
+	err := e.Write(counter)
+	return counter.written, err
+}
+
+// counter counts the amount of bytes written to the underlying io.Writer
+type counter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// Bytes renders the message and returns the result
+func (e *Envelope) Bytes() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := e.Write(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// String renders the message and returns the result as a string
+func (e *Envelope) String() (string, error) {
+	output, err := e.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+// Render renders the message once and returns the header block and body
+// separately (ex: to compute a DKIM or ARC signature before prepending it to
+// the headers). The header block includes the empty line separating the
+// headers from the body, the concatenation of both equals the Write output.
+func (e *Envelope) Render() ([]byte, []byte, error) {
+	message, err := e.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ending := e.LineEnding
+	if ending == "" {
+		ending = CRLF
+	}
+
+	separator := []byte(ending + ending)
+	index := bytes.Index(message, separator)
+	if index < 0 {
+		return nil, nil, ErrMalformedMessage
+	}
+
+	index += len(separator)
+	return message[:index], message[index:], nil
+}
+
+// RenderHeaders renders the message and returns the header block including
+// the empty line separating the headers from the body. The generated Date
+// and Message-ID are kept on the envelope but multipart boundaries are
+// generated on every render, use Render to retrieve the headers and body of
+// a single render unless a deterministic BoundaryFunc is set.
+func (e *Envelope) RenderHeaders() ([]byte, error) {
+	headers, _, err := e.Render()
+	return headers, err
+}
+
+// RenderBody renders the message and returns the body following the header
+// block. Multipart boundaries are generated on every render (see
+// RenderHeaders).
+func (e *Envelope) RenderBody() ([]byte, error) {
+	_, body, err := e.Render()
+	return body, err
+}
+
+// boundary generates a new multipart boundary
+func (e *Envelope) boundary() (string, error) {
+	if e.BoundaryFunc != nil {
+		return e.BoundaryFunc(), nil
+	}
+
+	return GenerateBoundary()
+}
+
+// domain returns the domain used for generated message identifiers
+func (e *Envelope) domain() string {
+	if e.MessageIDDomain != "" {
+		return e.MessageIDDomain
+	}
+
+	// The first author is used when the From field contains multiple addresses
+	addresses, err := ParseAddressList(e.author())
+	if err == nil {
+		address := addresses[0]
+		at := strings.LastIndex(address.Email, "@")
+		if at >= 0 && at < len(address.Email)-1 {
+			return address.Email[at+1:]
+		}
+	}
+
+	return "localhost"
+}
+
+// entropy is used as source of randomness for generated identifiers
+var entropy io.Reader = rand.Reader
+
+// fallback is used to generate unique identifiers once no entropy is available
+var fallback uint64
+
+// GenerateBoundary generates a new random boundary. An error is returned when
+// no random data could be read.
+func GenerateBoundary() (string, error) {
+	return random(30)
+}
+
+// RandomBoundary generates a new random boundary. A boundary based on the
+// current time is returned when no random data could be read.
 func RandomBoundary() string {
-	var buf [30]byte
-	_, err := io.ReadFull(rand.Reader, buf[:])
+	boundary, err := GenerateBoundary()
 	if err != nil {
-		panic(err)
+		return unique()
 	}
-	return fmt.Sprintf("%x", buf[:])
+
+	return boundary
+}
+
+// GenerateMessageID generates a new random message identifier (ex:
+// <4f3a...@example.com>) for the given domain. An error is returned when no
+// random data could be read.
+func GenerateMessageID(domain string) (string, error) {
+	id, err := random(16)
+	if err != nil {
+		return "", err
+	}
+
+	return "<" + id + "@" + domain + ">", nil
+}
+
+// RandomMessageID generates a new random message identifier (ex:
+// <4f3a...@example.com>) for the given domain. An identifier based on the
+// current time is returned when no random data could be read.
+func RandomMessageID(domain string) string {
+	id, err := GenerateMessageID(domain)
+	if err != nil {
+		return "<" + unique() + "@" + domain + ">"
+	}
+
+	return id
+}
+
+// msgID wraps the given message identifier inside angle brackets when missing
+func msgID(value string) string {
+	if strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">") {
+		return value
+	}
+
+	return "<" + value + ">"
+}
+
+// random generates a hex encoded random string of the given amount of bytes
+func random(size int) (string, error) {
+	buf := make([]byte, size)
+	_, err := io.ReadFull(entropy, buf)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", buf), nil
+}
+
+// unique generates a unique, but predictable, identifier based on the current
+// time and a counter
+func unique() string {
+	return fmt.Sprintf("%x.%x", time.Now().UnixNano(), atomic.AddUint64(&fallback, 1))
 }
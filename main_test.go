@@ -1,9 +1,26 @@
 package postbox
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -11,28 +28,34 @@ import (
 func TestWritingHeaders(t *testing.T) {
 	headers := []string{
 		"From: john@example.com",
+		"Sender: john@example.com",
 		"To: john@example.com",
 		"Reply-To: john@example.com",
-		"Mime-Version: 1.0",
+		"MIME-Version: 1.0",
 		"Date: Tue, 10 Nov 2009 23:00:00 +0100",
-		"Cc: john@example.com; boss@example.com",
+		"Cc: john@example.com, boss@example.com",
 		"Subject: hello world",
+		"Message-ID: <1@example.com>",
 	}
 
 	loc, _ := time.LoadLocation("Europe/Amsterdam")
 	envelope := Envelope{
-		Date:    time.Date(2009, 11, 10, 23, 0, 0, 0, loc),
-		From:    "john@example.com",
-		Sender:  "john@example.com",
-		ReplyTo: "john@example.com",
-		To:      []string{"john@example.com"},
-		Cc:      []string{"john@example.com", "boss@example.com"},
-		Subject: "hello world",
-		Charset: "UTF-8",
+		Date:      time.Date(2009, 11, 10, 23, 0, 0, 0, loc),
+		From:      "john@example.com",
+		Sender:    "john@example.com",
+		ReplyTo:   []string{"john@example.com"},
+		To:        []string{"john@example.com"},
+		Cc:        []string{"john@example.com", "boss@example.com"},
+		Subject:   "hello world",
+		MessageID: "<1@example.com>",
+		Charset:   "UTF-8",
+		Parts:     text("hello world"),
 	}
 
 	reader, writer := io.Pipe()
-	go envelope.Write(writer)
+	go func() {
+		writer.CloseWithError(envelope.Write(writer))
+	}()
 
 	last := ""
 	line := ""
@@ -77,31 +100,35 @@ func TestWritingContent(t *testing.T) {
 
 	expected := []string{
 		"Content-Type: text/plain; charset=UTF-8",
-		"Content-Transfer-Encoding: UTF-8",
+		"Content-Transfer-Encoding: 8bit",
 		plain,
 		"Content-Type: text/html; charset=UTF-8",
-		"Content-Transfer-Encoding: UTF-8",
+		"Content-Transfer-Encoding: 8bit",
 		html,
 	}
 
 	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
 		Charset: "UTF-8",
 		Parts: []*Part{
 			{
 				ContentType: "text/plain",
-				Encoding:    "UTF-8",
+				Encoding:    Unencoded,
 				Reader:      strings.NewReader(plain),
 			},
 			{
 				ContentType: "text/html",
-				Encoding:    "UTF-8",
+				Encoding:    Unencoded,
 				Reader:      strings.NewReader(html),
 			},
 		},
 	}
 
 	reader, writer := io.Pipe()
-	go envelope.Write(writer)
+	go func() {
+		writer.CloseWithError(envelope.Write(writer))
+	}()
 
 	last := ""
 	line := ""
@@ -140,3 +167,2744 @@ func TestWritingContent(t *testing.T) {
 		t.Fatal("Not all expectations were met:", expected)
 	}
 }
+
+// TestWritingAttachments test if attachments are written as base64 encoded parts
+func TestWritingAttachments(t *testing.T) {
+	content := strings.Repeat("%PDF-1.4 hello world ", 10)
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Attachments: []*File{
+			{
+				Name: "report.pdf",
+				Header: map[string][]string{
+					"Content-Type": {"application/pdf"},
+				},
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.WriteString(w, content)
+					return err
+				},
+			},
+		},
+	}
+
+	output := bytes.NewBuffer(nil)
+	err := envelope.Write(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"Content-Type: application/pdf",
+		"Content-Disposition: attachment; filename=\"report.pdf\"",
+		"Content-Transfer-Encoding: base64",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(output.String(), header+CRLF) {
+			t.Fatal("Expected header not found:", header)
+		}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	lines := strings.Split(output.String(), CRLF)
+	body := ""
+
+	for _, line := range lines {
+		if line == "" || !strings.HasPrefix(encoded[len(body):], line) {
+			continue
+		}
+
+		if len(line) > MaxLineLength {
+			t.Fatal("Line exceeds the maximum line length:", line)
+		}
+
+		body += line
+	}
+
+	if body != encoded {
+		t.Fatal("Attachment content not found:", body)
+	}
+}
+
+// TestWritingAttachmentEncoding test if attachments are written using their own transfer encoding
+func TestWritingAttachmentEncoding(t *testing.T) {
+	csv := "name,price\r\ncafé,=5\r\n"
+	pdf := []byte{'%', 'P', 'D', 'F', 0x00, 0xff}
+
+	report := AttachBytes("report.csv", "text/csv", []byte(csv))
+	report.Encoding = QuotedPrintable
+
+	envelope := Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Parts:       text("hello world"),
+		Attachments: []*File{report, AttachBytes("invoice.pdf", "application/pdf", pdf)},
+	}
+
+	_, output := render(t, &envelope)
+	if !strings.Contains(output, "name,price"+CRLF+"caf=C3=A9,=3D5"+CRLF) {
+		t.Fatal("Quoted-printable attachment not found:", output)
+	}
+
+	encodings := []Encoding{}
+	contents := []string{}
+
+	_, err := ReadMessage(strings.NewReader(output), func(part *MessagePart) error {
+		content, err := io.ReadAll(part.Body)
+		encodings = append(encodings, part.Encoding)
+		contents = append(contents, string(content))
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Encoding{QuotedPrintable, QuotedPrintable, Base64}
+	if fmt.Sprint(encodings) != fmt.Sprint(expected) {
+		t.Fatal("Unexpected encodings:", encodings)
+	}
+
+	if contents[1] != csv || contents[2] != string(pdf) {
+		t.Fatalf("Unexpected attachment contents: %q", contents[1:])
+	}
+
+	report.Encoding = "uuencode"
+
+	_, err = capture(&envelope)
+	if !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestWritingAttachmentEncodingHeader test if the transfer encoding header of a file matches the encoded content
+func TestWritingAttachmentEncodingHeader(t *testing.T) {
+	pdf := []byte{'%', 'P', 'D', 'F', 0x00, 0xff}
+
+	invoice := AttachBytes("invoice.pdf", "application/pdf", pdf)
+	invoice.Header["Content-Transfer-Encoding"] = []string{string(QuotedPrintable)}
+
+	envelope := Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Parts:       text("hello world"),
+		Attachments: []*File{invoice},
+	}
+
+	_, output := render(t, &envelope)
+	// Only the text part is quoted-printable encoded
+	if strings.Count(output, "Content-Transfer-Encoding: base64") != 1 || strings.Count(output, "quoted-printable") != 1 {
+		t.Fatal("Unexpected transfer encoding:", output)
+	}
+
+	var content []byte
+	_, err := ReadMessage(strings.NewReader(output), func(part *MessagePart) error {
+		if part.MediaType != "application/pdf" {
+			return nil
+		}
+
+		var err error
+		content, err = io.ReadAll(part.Body)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != string(pdf) {
+		t.Fatalf("Unexpected attachment content: %q", content)
+	}
+}
+
+// TestWritingDescription test if descriptions of parts and files are written as encoded Content-Description headers
+func TestWritingDescription(t *testing.T) {
+	invoice := AttachBytes("invoice.pdf", "application/pdf", []byte("%PDF"))
+	invoice.Description = "Monthly invoice PDF"
+
+	report := AttachBytes("report.csv", "text/csv", []byte("id,name\n"))
+	report.Description = "Rapport d'activité"
+
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"boss@example.com"},
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Description: "Plain text version",
+				Reader:      strings.NewReader("hello world"),
+			},
+		},
+		Attachments: []*File{invoice, report},
+	}
+
+	_, output := render(t, &envelope)
+
+	expected := []string{
+		"Content-Description: Plain text version",
+		"Content-Description: Monthly invoice PDF",
+		"Content-Description: =?UTF-8?q?Rapport_d'activit=C3=A9?=",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(output, header+CRLF) {
+			t.Fatal("Expected header not found:", header, output)
+		}
+	}
+
+	decoder := mime.WordDecoder{}
+	descriptions := []string{}
+
+	_, err := ReadMessage(strings.NewReader(output), func(part *MessagePart) error {
+		description, err := decoder.DecodeHeader(part.Header.Get("Content-Description"))
+		descriptions = append(descriptions, description)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(descriptions, "|") != "Plain text version|Monthly invoice PDF|Rapport d'activité" {
+		t.Fatal("Unexpected descriptions:", descriptions)
+	}
+}
+
+// TestWritingEmbedded test if embedded files are written as inline parts with a Content-ID
+func TestWritingEmbedded(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/html",
+				Encoding:    Unencoded,
+				Reader:      strings.NewReader(`<img src="cid:logo.png">`),
+			},
+		},
+		Embedded: []*File{
+			{
+				Name: "logo.png",
+				Header: map[string][]string{
+					"Content-Type": {"image/png"},
+				},
+				CopyFunc: func(w io.Writer) error {
+					_, err := w.Write([]byte{0x89, 'P', 'N', 'G'})
+					return err
+				},
+			},
+		},
+	}
+
+	output := bytes.NewBuffer(nil)
+	err := envelope.Write(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"Content-Type: image/png",
+		"Content-ID: <logo.png>",
+		"Content-Disposition: inline; filename=\"logo.png\"",
+		base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'}),
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output.String(), line+CRLF) {
+			t.Fatal("Expected line not found:", line)
+		}
+	}
+
+	if strings.Index(output.String(), "Content-ID") < strings.Index(output.String(), "cid:logo.png") {
+		t.Fatal("Embedded file written before the related content")
+	}
+}
+
+// TestWritingEmbeddedDisposition test if embedded files carry both a Content-ID and an inline disposition
+func TestWritingEmbeddedDisposition(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Embedded: []*File{
+			{
+				Name:      "lögo.png",
+				ContentID: "logo",
+				Header:    map[string][]string{"Content-Type": {"image/png"}},
+				CopyFunc:  func(w io.Writer) error { return nil },
+			},
+			{
+				ContentID: "<banner>",
+				Header:    map[string][]string{"Content-Type": {"image/png"}},
+				CopyFunc:  func(w io.Writer) error { return nil },
+			},
+		},
+	}
+
+	_, output := render(t, &envelope)
+
+	expected := []string{
+		"Content-Disposition: inline; filename*=utf-8''l%C3%B6go.png" + CRLF + "Content-ID: <logo>" + CRLF,
+		"Content-Disposition: inline" + CRLF + "Content-ID: <banner>" + CRLF,
+	}
+
+	for _, headers := range expected {
+		if !strings.Contains(output, headers) {
+			t.Fatal("Expected headers not found:", headers)
+		}
+	}
+}
+
+// TestWritingQuotedPrintable test if quoted-printable parts are encoded and could be decoded again
+func TestWritingQuotedPrintable(t *testing.T) {
+	body := "café = hello"
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    QuotedPrintable,
+		Reader:      strings.NewReader(body),
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	part.Write(buffer, "UTF-8")
+
+	sections := strings.SplitN(buffer.String(), CRLF+CRLF, 2)
+	if len(sections) != 2 {
+		t.Fatal("Unexpected part:", buffer.String())
+	}
+
+	encoded := strings.TrimSuffix(sections[1], CRLF)
+	if encoded != "caf=C3=A9 =3D hello" {
+		t.Fatal("Unexpected encoded body:", encoded)
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != body {
+		t.Fatal("Unexpected decoded body:", string(decoded))
+	}
+}
+
+// TestWritingQuotedPrintableLineLength test if long quoted-printable lines are broken with soft line breaks
+func TestWritingQuotedPrintableLineLength(t *testing.T) {
+	body := strings.Repeat("Grüße aus Köln, één keer per week ", 10) + "trailing whitespace \t " + CRLF + "end"
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    QuotedPrintable,
+		Reader:      strings.NewReader(body),
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := part.Write(buffer, "UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections := strings.SplitN(buffer.String(), CRLF+CRLF, 2)
+	if len(sections) != 2 {
+		t.Fatal("Unexpected part:", buffer.String())
+	}
+
+	encoded := strings.TrimSuffix(sections[1], CRLF)
+	lines := strings.Split(encoded, CRLF)
+	if len(lines) < 3 {
+		t.Fatal("Quoted-printable content has not been wrapped:", encoded)
+	}
+
+	for _, line := range lines {
+		if len(line) > MaxLineLength {
+			t.Fatal("Line exceeds the maximum line length:", line)
+		}
+
+		if strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") {
+			t.Fatal("Trailing whitespace has not been encoded:", line)
+		}
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != body {
+		t.Fatal("Unexpected decoded body:", string(decoded))
+	}
+}
+
+// TestWritingBase64LineLength test if base64 encoded parts are wrapped at the maximum line length
+func TestWritingBase64LineLength(t *testing.T) {
+	body := strings.Repeat("hello world ", 100)
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    Base64,
+		Reader:      strings.NewReader(body),
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	part.Write(buffer, "UTF-8")
+
+	sections := strings.SplitN(buffer.String(), CRLF+CRLF, 2)
+	if len(sections) != 2 {
+		t.Fatal("Unexpected part:", buffer.String())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(sections[1], CRLF), CRLF)
+	if len(lines) < 2 {
+		t.Fatal("Base64 content has not been wrapped")
+	}
+
+	for _, line := range lines {
+		if len(line) > MaxLineLength {
+			t.Fatal("Line exceeds the maximum line length:", line)
+		}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != body {
+		t.Fatal("Unexpected decoded body:", string(decoded))
+	}
+}
+
+// TestHeadersOrder test if headers are written in a deterministic order
+func TestHeadersOrder(t *testing.T) {
+	headers := Headers{
+		"X-Mailer":     {"postbox"},
+		"Subject":      {"hello world"},
+		"Mime-Version": {"1.0"},
+		"To":           {"john@example.com"},
+		"From":         {"john@example.com"},
+		"Date":         {"Tue, 10 Nov 2009 23:00:00 +0100"},
+	}
+
+	expected := "Date: Tue, 10 Nov 2009 23:00:00 +0100" + CRLF +
+		"From: john@example.com" + CRLF +
+		"To: john@example.com" + CRLF +
+		"Subject: hello world" + CRLF +
+		"MIME-Version: 1.0" + CRLF +
+		"X-Mailer: postbox" + CRLF
+
+	for i := 0; i < 10; i++ {
+		buffer := bytes.NewBuffer(nil)
+		headers.Write(buffer)
+
+		if buffer.String() != expected {
+			t.Fatal("Unexpected headers:", buffer.String())
+		}
+	}
+}
+
+// text constructs a new quoted-printable text/plain part with the given body
+func text(body string) []*Part {
+	return []*Part{
+		{
+			ContentType: "text/plain",
+			Encoding:    QuotedPrintable,
+			Reader:      strings.NewReader(body),
+		},
+	}
+}
+
+// render writes the given envelope and returns the header block and output
+func render(t *testing.T, envelope *Envelope) (string, string) {
+	output := bytes.NewBuffer(nil)
+	err := envelope.Write(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := strings.SplitN(output.String(), CRLF+CRLF, 2)[0] + CRLF
+	return headers, output.String()
+}
+
+// capture writes the given envelope and returns the written output and error
+func capture(envelope *Envelope) (string, error) {
+	output := bytes.NewBuffer(nil)
+	err := envelope.Write(output)
+	return output.String(), err
+}
+
+// TestWritingFromAddress test if the typed From address is encoded and preferred over the From field
+func TestWritingFromAddress(t *testing.T) {
+	envelope := Envelope{
+		From:        "Jane Doe <jane@example.com>, Boss <boss@example.com>",
+		FromAddress: Address{Name: "Jöhn Doe", Email: "john@example.com"},
+		To:          []string{"boss@example.com"},
+		Parts:       text("hello world"),
+	}
+
+	headers, output := render(t, &envelope)
+	if !strings.Contains(headers, "From: =?utf-8?q?J=C3=B6hn_Doe?= <john@example.com>"+CRLF) {
+		t.Fatal("Unexpected From header:", headers)
+	}
+
+	if strings.Contains(headers, "Sender:") {
+		t.Fatal("Unexpected Sender header:", headers)
+	}
+
+	if !strings.HasSuffix(envelope.MessageID, "@example.com>") || envelope.EnvelopeFrom() != "john@example.com" {
+		t.Fatal("Unexpected envelope from:", envelope.EnvelopeFrom())
+	}
+
+	parsed, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.From != `"Jöhn Doe" <john@example.com>` {
+		t.Fatal("Unexpected parsed From:", parsed.From)
+	}
+
+	envelope.FromAddress = Address{Name: "Jöhn Doe"}
+	envelope.Sender = "secretary@example.com"
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, `From: "Jane Doe" <jane@example.com>, "Boss" <boss@example.com>`+CRLF) {
+		t.Fatal("Unexpected From header:", headers)
+	}
+
+	envelope = Envelope{
+		FromAddress: Address{Email: "john@example.com"},
+		To:          []string{"boss@example.com"},
+		Parts:       text("hello world"),
+	}
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, "From: john@example.com"+CRLF) {
+		t.Fatal("Unexpected From header:", headers)
+	}
+}
+
+// TestWritingSender test if the Sender header is only written when set
+func TestWritingSender(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	if strings.Contains(headers, "Sender:") {
+		t.Fatal("Unexpected Sender header:", headers)
+	}
+
+	envelope.Sender = "secretary@example.com"
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, "Sender: secretary@example.com"+CRLF) {
+		t.Fatal("Sender header not found:", headers)
+	}
+}
+
+// TestWritingMultipleFrom test if multiple authors are written and require a Sender
+func TestWritingMultipleFrom(t *testing.T) {
+	envelope := Envelope{
+		From:  "John Doe <john@example.com>, Jürgen <jurgen@example.com>",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	_, err := capture(&envelope)
+	if !errors.Is(err, ErrNoSender) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	envelope.Sender = "john@example.com"
+
+	headers, _ := render(t, &envelope)
+	if !strings.Contains(strings.ReplaceAll(headers, CRLF+" ", " "), `From: "John Doe" <john@example.com>, =?utf-8?q?J=C3=BCrgen?= <jurgen@example.com>`+CRLF) {
+		t.Fatal("Unexpected From header:", headers)
+	}
+
+	if !strings.Contains(headers, "Sender: john@example.com"+CRLF) {
+		t.Fatal("Sender header not found:", headers)
+	}
+
+	if !strings.HasSuffix(envelope.MessageID, "@example.com>") {
+		t.Fatal("Unexpected message id:", envelope.MessageID)
+	}
+}
+
+// TestWritingEmptyAddresses test if empty address headers are omitted
+func TestWritingEmptyAddresses(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		Cc:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	if !strings.Contains(headers, "Cc: boss@example.com"+CRLF) {
+		t.Fatal("Cc header not found:", headers)
+	}
+
+	for _, header := range []string{"To:", "Reply-To:"} {
+		if strings.Contains(headers, header) {
+			t.Fatal("Unexpected empty header:", header, headers)
+		}
+	}
+}
+
+// TestWritingBcc test if Bcc recipients are never written to the message
+func TestWritingBcc(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Bcc:     []string{"secret@example.com"},
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	_, output := render(t, &envelope)
+	if strings.Contains(output, "secret@example.com") || strings.Contains(output, "Bcc") {
+		t.Fatal("Bcc recipient written to the message:", output)
+	}
+}
+
+// TestWritingMessageID test if a well-formed Message-ID is generated
+func TestWritingMessageID(t *testing.T) {
+	envelope := Envelope{
+		From:    "John Doe <john@example.com>",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	message, err := mail.ReadMessage(strings.NewReader(headers + CRLF))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := message.Header.Get("Message-ID")
+	if !regexp.MustCompile(`^<[0-9a-f]{32}@example\.com>$`).MatchString(id) {
+		t.Fatal("Unexpected Message-ID:", id)
+	}
+
+	envelope = Envelope{
+		From:            "john@example.com",
+		To:              []string{"boss@example.com"},
+		Charset:         "UTF-8",
+		MessageIDDomain: "mail.example.com",
+		Parts:           text("hello world"),
+	}
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, "@mail.example.com>"+CRLF) {
+		t.Fatal("Message-ID domain not used:", headers)
+	}
+
+	envelope.MessageID = "custom@example.com"
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, "Message-ID: <custom@example.com>"+CRLF) {
+		t.Fatal("Message-ID not overridden:", headers)
+	}
+}
+
+// TestWritingThreading test if the In-Reply-To and References headers are only written when set
+func TestWritingThreading(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	if strings.Contains(headers, "In-Reply-To") || strings.Contains(headers, "References") {
+		t.Fatal("Unexpected threading headers:", headers)
+	}
+
+	envelope.InReplyTo = "<2@example.com>"
+	envelope.References = []string{"<1@example.com>", "2@example.com"}
+
+	headers, _ = render(t, &envelope)
+	expected := []string{
+		"In-Reply-To: <2@example.com>",
+		"References: <1@example.com> <2@example.com>",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(headers, header+CRLF) {
+			t.Fatal("Expected header not found:", header, headers)
+		}
+	}
+}
+
+// TestWritingCustomHeaders test if custom headers are written alongside the standard headers
+func TestWritingCustomHeaders(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Charset: "UTF-8",
+		Headers: Headers{
+			"X-Mailer": {"postbox"},
+		},
+		Parts: text("hello world"),
+	}
+
+	headers, _ := render(t, &envelope)
+	expected := []string{
+		"From: john@example.com",
+		"Subject: hello world",
+		"X-Mailer: postbox",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(headers, header+CRLF) {
+			t.Fatal("Expected header not found:", header, headers)
+		}
+	}
+
+	envelope.Headers = Headers{
+		"X-Mailer\r\nBcc": {"attacker@example.com"},
+	}
+
+	_, err := capture(&envelope)
+	if !errors.Is(err, ErrInvalidHeaderName) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestEnvelopeString test if the rendered message is returned as bytes and string
+func TestEnvelopeString(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("hello world"),
+			},
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output, "Subject: hello world"+CRLF) || !strings.Contains(output, "hello world"+CRLF) {
+		t.Fatal("Unexpected output:", output)
+	}
+
+	envelope.Subject = "hello\r\nworld"
+
+	result, err := envelope.Bytes()
+	if !errors.Is(err, ErrInvalidHeaderValue) || result != nil {
+		t.Fatal("Unexpected result:", result, err)
+	}
+}
+
+// structure parses the given message and returns its MIME tree (ex:
+// multipart/mixed(text/plain,image/png))
+func structure(t *testing.T, message string) string {
+	parsed, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return walk(t, parsed.Header.Get("Content-Type"), parsed.Body)
+}
+
+// walk walks the given MIME entity and returns its tree representation
+func walk(t *testing.T, contentType string, body io.Reader) string {
+	media, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(media, "multipart/") {
+		_, err := io.Copy(io.Discard, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return media
+	}
+
+	children := []string{}
+	reader := multipart.NewReader(body, params["boundary"])
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		children = append(children, walk(t, part.Header.Get("Content-Type"), part))
+	}
+
+	return media + "(" + strings.Join(children, ",") + ")"
+}
+
+// TestWritingNestedBoundaries test if the written message is a valid nested MIME tree
+func TestWritingNestedBoundaries(t *testing.T) {
+	file := func(name string, contentType string) *File {
+		return &File{
+			Name:   name,
+			Header: map[string][]string{"Content-Type": {contentType}},
+			CopyFunc: func(w io.Writer) error {
+				_, err := io.WriteString(w, name)
+				return err
+			},
+		}
+	}
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("hello world"),
+			},
+			{
+				ContentType: "text/html",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader(`<p>hello <img src="cid:logo.png"></p>`),
+			},
+		},
+		Embedded:    []*File{file("logo.png", "image/png")},
+		Attachments: []*File{file("report.pdf", "application/pdf")},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/png),application/pdf)"
+	result := structure(t, output)
+
+	if result != expected {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+}
+
+// TestWritingMinimalStructure test if only the required multipart levels are written
+func TestWritingMinimalStructure(t *testing.T) {
+	plain := func() *Part {
+		return &Part{ContentType: "text/plain", Encoding: QuotedPrintable, Reader: strings.NewReader("hello world")}
+	}
+
+	html := func() *Part {
+		return &Part{ContentType: "text/html", Encoding: QuotedPrintable, Reader: strings.NewReader("<p>hello world</p>")}
+	}
+
+	attachment := func() *File {
+		return &File{
+			Name:   "report.pdf",
+			Header: map[string][]string{"Content-Type": {"application/pdf"}},
+			CopyFunc: func(w io.Writer) error {
+				_, err := io.WriteString(w, "%PDF-1.4")
+				return err
+			},
+		}
+	}
+
+	tests := map[string]Envelope{
+		"text/plain": {
+			Parts: []*Part{plain()},
+		},
+		"multipart/alternative(text/plain,text/html)": {
+			Parts: []*Part{plain(), html()},
+		},
+		"multipart/mixed(multipart/alternative(text/plain,text/html),application/pdf)": {
+			Parts:       []*Part{plain(), html()},
+			Attachments: []*File{attachment()},
+		},
+	}
+
+	for expected, envelope := range tests {
+		envelope.From = "john@example.com"
+		envelope.To = []string{"boss@example.com"}
+		envelope.Charset = "UTF-8"
+
+		output, err := envelope.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := structure(t, output)
+		if result != expected {
+			t.Fatal("Unexpected MIME tree:", result, "expected:", expected)
+		}
+	}
+}
+
+// TestWritingSinglePart test if a message containing a single part is written without boundaries
+func TestWritingSinglePart(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	headers, output := render(t, &envelope)
+	if strings.Contains(output, "boundary") || strings.Contains(output, "multipart") {
+		t.Fatal("Unexpected multipart message:", output)
+	}
+
+	if !strings.Contains(headers, "Content-Type: text/plain; charset=utf-8"+CRLF) || !strings.Contains(headers, "MIME-Version: 1.0"+CRLF) {
+		t.Fatal("Unexpected headers:", headers)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSuffix(string(body), CRLF) != "hello world" {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+}
+
+// TestWritingContentHeaders test if content headers are rejected inside the message headers
+func TestWritingContentHeaders(t *testing.T) {
+	for _, property := range []string{"Content-Type", "content-transfer-encoding", "Content-Language"} {
+		envelope := Envelope{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com"},
+			Parts:   text("hello world"),
+			Headers: Headers{property: {"text/html"}},
+		}
+
+		_, err := capture(&envelope)
+		if !errors.Is(err, ErrContentHeader) {
+			t.Fatal("Unexpected error:", property, err)
+		}
+
+		err = envelope.Validate()
+		if !errors.Is(err, ErrContentHeader) {
+			t.Fatal("Unexpected validation error:", property, err)
+		}
+	}
+}
+
+// TestEncodingValidate test if only known transfer encodings are accepted
+func TestEncodingValidate(t *testing.T) {
+	for _, encoding := range []Encoding{QuotedPrintable, Base64, Unencoded, SevenBit, Binary} {
+		err := encoding.Validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, encoding := range []Encoding{"", "UTF-8", "BASE64"} {
+		err := encoding.Validate()
+		if !errors.Is(err, ErrUnknownEncoding) {
+			t.Fatal("Unexpected error:", encoding, err)
+		}
+	}
+}
+
+// TestWritingUnchangedEncodings test if 7bit and binary parts are written as is
+func TestWritingUnchangedEncodings(t *testing.T) {
+	for _, encoding := range []Encoding{SevenBit, Binary} {
+		part := Part{
+			ContentType: "text/plain",
+			Encoding:    encoding,
+			Reader:      strings.NewReader("hello = world"),
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err := part.Write(buffer, "UTF-8")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "Content-Transfer-Encoding: " + string(encoding) + CRLF
+		if !strings.Contains(buffer.String(), expected) || !strings.HasSuffix(buffer.String(), CRLF+"hello = world"+CRLF) {
+			t.Fatal("Unexpected part:", buffer.String())
+		}
+	}
+}
+
+// TestWritingDefaultCharset test if the default charset is used when no charset has been set
+func TestWritingDefaultCharset(t *testing.T) {
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"boss@example.com"},
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("hello world"),
+			},
+			{
+				ContentType: "text/html",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("<p>hello world</p>"),
+				Charset:     "ISO-8859-1",
+			},
+		},
+	}
+
+	_, output := render(t, &envelope)
+	expected := []string{
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=ISO-8859-1",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(output, header+CRLF) {
+			t.Fatal("Expected header not found:", header, output)
+		}
+	}
+
+	if envelope.Charset != DefaultCharset {
+		t.Fatal("Unexpected envelope charset:", envelope.Charset)
+	}
+}
+
+// TestWritingForce7Bit test if 8bit parts are base64 encoded when 7bit is forced
+func TestWritingForce7Bit(t *testing.T) {
+	body := "Grüße aus Köln"
+	part := &Part{
+		ContentType: "text/plain",
+		Encoding:    Unencoded,
+		Reader:      strings.NewReader(body),
+	}
+
+	envelope := Envelope{
+		From:      "john@example.com",
+		To:        []string{"boss@example.com"},
+		Parts:     []*Part{part},
+		Force7Bit: true,
+	}
+
+	output, err := capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < len(output); index++ {
+		if output[index] >= 0x80 {
+			t.Fatal("Unexpected 8bit character inside the message:", output)
+		}
+	}
+
+	if !strings.Contains(output, "Content-Transfer-Encoding: base64"+CRLF) || part.Encoding != Unencoded {
+		t.Fatal("Unexpected encoding:", output)
+	}
+
+	encoded := strings.TrimSuffix(strings.SplitN(output, CRLF+CRLF, 2)[1], CRLF)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != body {
+		t.Fatal("Unexpected decoded body:", string(decoded))
+	}
+}
+
+// TestWritingInvalidEncoding test if parts with an unknown transfer encoding are rejected
+func TestWritingInvalidEncoding(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    "UTF-8",
+				Reader:      strings.NewReader("hello world"),
+			},
+		},
+	}
+
+	output, err := capture(&envelope)
+	if !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if strings.Contains(output, "Content-Transfer-Encoding: UTF-8") {
+		t.Fatal("Invalid encoding written:", output)
+	}
+}
+
+// TestWritingFileContent test if the file contents are base64 encoded inside the correct MIME section
+func TestWritingFileContent(t *testing.T) {
+	content := bytes.Repeat([]byte{0, 1, 2, 3, 0xff}, 100)
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("hello world"),
+			},
+		},
+		Attachments: []*File{
+			{
+				Name: "data.bin",
+				CopyFunc: func(w io.Writer) error {
+					_, err := w.Write(content)
+					return err
+				},
+			},
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	found := false
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if part.FileName() != "data.bin" {
+			continue
+		}
+
+		if part.Header.Get("Content-Transfer-Encoding") != "base64" {
+			t.Fatal("Unexpected encoding:", part.Header)
+		}
+
+		decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decoded, content) {
+			t.Fatal("Unexpected file content:", decoded)
+		}
+
+		found = true
+	}
+
+	if !found {
+		t.Fatal("Attachment not found:", output)
+	}
+
+	envelope.Attachments[0].CopyFunc = nil
+	envelope.Parts[0].Reader = strings.NewReader("hello world")
+
+	_, err = envelope.String()
+	if !errors.Is(err, ErrNoCopyFunc) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestBoundaryQuoted test if boundary identifiers containing special characters are quoted
+func TestBoundaryQuoted(t *testing.T) {
+	tests := map[string]string{
+		"simple_boundary.1": "boundary=simple_boundary.1",
+		"hello world":       `boundary="hello world"`,
+		"=_part:1":          `boundary="=_part:1"`,
+	}
+
+	for identifier, expected := range tests {
+		envelope := Envelope{
+			From:         "john@example.com",
+			To:           []string{"boss@example.com"},
+			BoundaryFunc: func() string { return identifier },
+			Parts:        text("hello world"),
+			Attachments:  []*File{AttachBytes("report.csv", "text/csv", []byte("id,name\n"))},
+		}
+
+		headers, output := render(t, &envelope)
+		if !strings.Contains(headers, "Content-Type: multipart/mixed; "+expected+CRLF) {
+			t.Fatal("Unexpected boundary parameter:", headers)
+		}
+
+		if structure(t, output) != "multipart/mixed(text/plain,text/csv)" {
+			t.Fatal("Unexpected MIME tree:", structure(t, output))
+		}
+	}
+}
+
+// TestBoundaryNesting test if nested boundaries do not introduce empty lines or parts
+func TestBoundaryNesting(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+
+	outer := StartBoundary(buffer, "outer", "multipart/mixed")
+	outer.Mark()
+
+	inner := StartBoundary(buffer, "inner", "multipart/alternative")
+
+	for _, content := range []string{"first", "second"} {
+		inner.Mark()
+		buffer.WriteString("Content-Type: text/plain" + CRLF + CRLF + content + CRLF)
+	}
+
+	inner.End()
+	outer.Mark()
+	buffer.WriteString("Content-Type: text/csv" + CRLF + CRLF + "a,b" + CRLF)
+	outer.End()
+
+	output := buffer.String()
+	if !strings.Contains(output, "second"+CRLF+"--inner--"+CRLF+"--outer"+CRLF) {
+		t.Fatalf("Unexpected nested close delimiter: %q", output)
+	}
+
+	if !strings.HasSuffix(output, "a,b"+CRLF+"--outer--"+CRLF) {
+		t.Fatalf("Unexpected close delimiter: %q", output)
+	}
+
+	// parts returns the content of all (nested) parts inside the given body
+	var parts func(body io.Reader, boundary string) []string
+	parts = func(body io.Reader, boundary string) []string {
+		result := []string{}
+		reader := multipart.NewReader(body, boundary)
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return result
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			media, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if params["boundary"] != "" {
+				result = append(result, parts(part, params["boundary"])...)
+				continue
+			}
+
+			content, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result = append(result, media+":"+string(content))
+		}
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := parts(parsed.Body, "outer")
+	if strings.Join(result, ",") != "text/plain:first,text/plain:second,text/csv:a,b" {
+		t.Fatalf("Unexpected parts: %q", result)
+	}
+}
+
+// TestWritingBoundaryFunc test if the boundary generator is used for all boundaries
+func TestWritingBoundaryFunc(t *testing.T) {
+	newEnvelope := func() *Envelope {
+		counter := 0
+
+		return &Envelope{
+			Date:      time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC),
+			MessageID: "<1@example.com>",
+			From:      "john@example.com",
+			To:        []string{"boss@example.com"},
+			Charset:   "UTF-8",
+			BoundaryFunc: func() string {
+				boundary := fmt.Sprintf("boundary-%d", counter)
+				counter++
+				return boundary
+			},
+			Parts: []*Part{
+				{ContentType: "text/plain", Encoding: QuotedPrintable, Reader: strings.NewReader("hello world")},
+				{ContentType: "text/html", Encoding: QuotedPrintable, Reader: strings.NewReader("<p>hello world</p>")},
+			},
+			Attachments: []*File{
+				{
+					Name: "hello.txt",
+					CopyFunc: func(w io.Writer) error {
+						_, err := io.WriteString(w, "hello world")
+						return err
+					},
+				},
+			},
+		}
+	}
+
+	first, err := newEnvelope().String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := newEnvelope().String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Fatal("Rendered messages are not identical:", first, second)
+	}
+
+	expected := []string{
+		"Content-Type: multipart/mixed; boundary=boundary-0",
+		"--boundary-0",
+		"Content-Type: multipart/alternative; boundary=boundary-1",
+		"--boundary-1",
+		"--boundary-1--",
+		"--boundary-0--",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(first, CRLF+line+CRLF) {
+			t.Fatal("Expected line not found:", line, first)
+		}
+	}
+}
+
+// TestGeneratingWithoutEntropy test if generation errors are returned instead of panicking
+func TestGeneratingWithoutEntropy(t *testing.T) {
+	failure := errors.New("no entropy available")
+	entropy = iotest.ErrReader(failure)
+	defer func() {
+		entropy = rand.Reader
+	}()
+
+	_, err := GenerateBoundary()
+	if !errors.Is(err, failure) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if RandomBoundary() == RandomBoundary() {
+		t.Fatal("Fallback boundaries are not unique")
+	}
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts:   text("hello world"),
+	}
+
+	_, err = envelope.String()
+	if !errors.Is(err, failure) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	envelope.MessageID = "<1@example.com>"
+	envelope.Parts = []*Part{
+		{ContentType: "text/plain", Encoding: QuotedPrintable, Reader: strings.NewReader("hello world")},
+		{ContentType: "text/html", Encoding: QuotedPrintable, Reader: strings.NewReader("<p>hello world</p>")},
+	}
+
+	_, err = envelope.String()
+	if !errors.Is(err, failure) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestWritingContentID test if the file Content-ID matches the cid URL used inside the HTML part
+func TestWritingContentID(t *testing.T) {
+	logo := &File{
+		Name:      "logo.png",
+		ContentID: "logo@example.com",
+		CopyFunc: func(w io.Writer) error {
+			_, err := w.Write([]byte{0x89, 'P', 'N', 'G'})
+			return err
+		},
+	}
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/html",
+				Encoding:    Unencoded,
+				Reader:      strings.NewReader(`<img src="` + logo.URL() + `">`),
+			},
+		},
+		Embedded: []*File{logo},
+	}
+
+	_, output := render(t, &envelope)
+	if !strings.Contains(output, `<img src="cid:logo@example.com">`) {
+		t.Fatal("Content-ID not referenced:", output)
+	}
+
+	if !strings.Contains(output, "Content-ID: <logo@example.com>"+CRLF) {
+		t.Fatal("Content-ID not found:", output)
+	}
+
+	unnamed := &File{
+		CopyFunc: logo.CopyFunc,
+	}
+
+	envelope.Embedded = []*File{unnamed}
+	envelope.Parts[0].Reader = strings.NewReader("hello world")
+
+	_, output = render(t, &envelope)
+	if unnamed.ContentID == "" || !strings.Contains(output, "Content-ID: <"+unnamed.ContentID+">"+CRLF) {
+		t.Fatal("Content-ID not generated:", output)
+	}
+}
+
+// TestWritingContentIDEscaping test if file names which are not allowed inside a Content-ID are percent-encoded
+func TestWritingContentIDEscaping(t *testing.T) {
+	logo := &File{
+		Name: "my lögo.png",
+		CopyFunc: func(w io.Writer) error {
+			_, err := w.Write([]byte{0x89, 'P', 'N', 'G'})
+			return err
+		},
+	}
+
+	if logo.ID() != "my%20l%C3%B6go.png" {
+		t.Fatal("Unexpected id:", logo.ID())
+	}
+
+	if logo.URL() != "cid:my%2520l%25C3%25B6go.png" {
+		t.Fatal("Unexpected URL:", logo.URL())
+	}
+
+	envelope := Envelope{
+		From:     "john@example.com",
+		To:       []string{"boss@example.com"},
+		Parts:    text("hello world"),
+		Embedded: []*File{logo},
+	}
+
+	_, output := render(t, &envelope)
+	if !strings.Contains(output, "Content-ID: <my%20l%C3%B6go.png>"+CRLF) {
+		t.Fatal("Content-ID not escaped:", output)
+	}
+}
+
+// TestWritingEmptyMessage test if writing an envelope without content is rejected
+func TestWritingEmptyMessage(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+	}
+
+	output, err := capture(&envelope)
+	if !errors.Is(err, ErrEmptyMessage) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if output != "" {
+		t.Fatal("Unexpected output:", output)
+	}
+}
+
+// TestWritingRequiredAddresses test if envelopes without From or recipients are rejected
+func TestWritingRequiredAddresses(t *testing.T) {
+	envelope := Envelope{
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	_, err := capture(&envelope)
+	if !errors.Is(err, ErrNoFrom) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	envelope = Envelope{
+		From:  "john@example.com",
+		Parts: text("hello world"),
+	}
+
+	_, err = capture(&envelope)
+	if !errors.Is(err, ErrNoRecipients) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	envelope.Bcc = []string{"secret@example.com"}
+
+	_, err = capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEnvelopeWriteTo test if the amount of written bytes is returned
+func TestEnvelopeWriteTo(t *testing.T) {
+	var _ io.WriterTo = &Envelope{}
+
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Parts:   text("hello world"),
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	n, err := envelope.WriteTo(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n == 0 || n != int64(buffer.Len()) {
+		t.Fatal("Unexpected byte count:", n, buffer.Len())
+	}
+}
+
+// TestWriteContext test if writing stops once the context has been cancelled
+func TestWriteContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chunk := bytes.Repeat([]byte("x"), 1024)
+	chunks := 1024
+	copied := 0
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Attachments: []*File{
+			{
+				Name: "large.bin",
+				CopyFunc: func(w io.Writer) error {
+					for index := 0; index < chunks; index++ {
+						if index == 10 {
+							cancel()
+						}
+
+						_, err := w.Write(chunk)
+						if err != nil {
+							return err
+						}
+
+						copied++
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := envelope.WriteContext(ctx, buffer)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if copied >= chunks || buffer.Len() > len(chunk)*chunks {
+		t.Fatal("Writing did not stop early:", copied, buffer.Len())
+	}
+
+	buffer.Reset()
+
+	err = envelope.WriteContext(ctx, buffer)
+	if !errors.Is(err, context.Canceled) || buffer.Len() != 0 {
+		t.Fatal("Unexpected result:", err, buffer.Len())
+	}
+}
+
+// failingWriter fails once more than the given limit of bytes is written
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.written+len(p) > f.limit {
+		n := f.limit - f.written
+		f.written = f.limit
+		return n, io.ErrShortWrite
+	}
+
+	f.written += len(p)
+	return len(p), nil
+}
+
+// TestWriteFailure test if write failures are returned at every position of the message
+func TestWriteFailure(t *testing.T) {
+	envelope := Envelope{
+		From:         "john@example.com",
+		To:           []string{"boss@example.com"},
+		Date:         time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		MessageID:    "id@example.com",
+		BoundaryFunc: func() string { return "boundary" },
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				ReaderFunc: func() io.Reader {
+					return strings.NewReader("hello world")
+				},
+			},
+		},
+		Attachments: []*File{
+			{
+				Name: "hello.txt",
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.WriteString(w, "hello world")
+					return err
+				},
+			},
+		},
+	}
+
+	message, err := envelope.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for limit := 0; limit < len(message); limit++ {
+		err := envelope.Write(&failingWriter{limit: limit})
+		if !errors.Is(err, io.ErrShortWrite) {
+			t.Fatalf("Unexpected error after %d of %d bytes: %v", limit, len(message), err)
+		}
+	}
+
+	err = envelope.Write(&failingWriter{limit: len(message)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWriteContextDeadline test if blocked writes to a connection are interrupted once the context is done
+func TestWriteContextDeadline(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	type test struct {
+		context func() (context.Context, context.CancelFunc)
+		err     error
+	}
+
+	tests := map[string]test{
+		"deadline": {
+			context: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			err: context.DeadlineExceeded,
+		},
+		"cancelled": {
+			context: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			err: context.Canceled,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The remote end is never read, writes block until interrupted
+			conn, remote := net.Pipe()
+			defer conn.Close()
+			defer remote.Close()
+
+			ctx, cancel := test.context()
+			defer cancel()
+
+			result := make(chan error, 1)
+			go func() {
+				result <- envelope.WriteContext(ctx, conn)
+			}()
+
+			select {
+			case err := <-result:
+				if !errors.Is(err, test.err) {
+					t.Fatal("Unexpected error:", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Write has not been interrupted")
+			}
+
+			go io.Copy(io.Discard, remote)
+
+			err := envelope.Write(conn)
+			if err != nil {
+				t.Fatal("Unexpected error, write deadline has not been cleared:", err)
+			}
+		})
+	}
+}
+
+// TestWriteContextFile test if messages could be written to writers not supporting write deadlines
+func TestWriteContextFile(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		file, err := os.CreateTemp(t.TempDir(), "message")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = envelope.WriteContext(ctx, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Close()
+
+		if info.Size() == 0 {
+			t.Fatal("Message has not been written")
+		}
+	}
+}
+
+// TestWriteContextCallerDeadline test if write deadlines set by the caller are kept
+func TestWriteContextCallerDeadline(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		// The remote end is never read, writes block until the deadline expires
+		conn, remote := net.Pipe()
+		defer conn.Close()
+		defer remote.Close()
+
+		conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+
+		result := make(chan error, 1)
+		go func() {
+			result <- envelope.WriteContext(ctx, conn)
+		}()
+
+		select {
+		case err := <-result:
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatal("Unexpected error:", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Write deadline has not been respected")
+		}
+	}
+}
+
+// TestWritingDate test if the Date header defaults to now and could be parsed by net/mail
+func TestWritingDate(t *testing.T) {
+	before := time.Now().Truncate(time.Second)
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	message, err := envelope.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date, err := parsed.Header.Date()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if date.Before(before) || date.After(time.Now()) {
+		t.Fatal("Unexpected default date:", date)
+	}
+
+	loc := time.FixedZone("Europe/Amsterdam", 2*60*60)
+
+	envelope.Date = time.Date(2021, 5, 3, 9, 4, 5, 0, time.UTC)
+	envelope.DateLocation = loc
+	envelope.Parts = text("hello world")
+
+	headers, _ := render(t, &envelope)
+	if !strings.Contains(headers, "Date: Mon, 03 May 2021 11:04:05 +0200"+CRLF) {
+		t.Fatal("Unexpected date header:", headers)
+	}
+
+	value := headers[strings.Index(headers, "Date: ")+len("Date: "):]
+	value = value[:strings.Index(value, CRLF)]
+
+	date, err = mail.ParseDate(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !date.Equal(envelope.Date) {
+		t.Fatal("Unexpected parsed date:", date)
+	}
+}
+
+// TestWritingClock test if the envelope clock is used when no Date is set
+func TestWritingClock(t *testing.T) {
+	clock := func() time.Time {
+		return time.Date(2021, 5, 3, 9, 4, 5, 0, time.UTC)
+	}
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Clock: clock,
+	}
+
+	headers, _ := render(t, &envelope)
+	if !strings.Contains(headers, "Date: Mon, 03 May 2021 09:04:05 +0000"+CRLF) {
+		t.Fatal("Unexpected date header:", headers)
+	}
+
+	envelope = Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Date:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Parts: text("hello world"),
+		Clock: clock,
+	}
+
+	headers, _ = render(t, &envelope)
+	if !strings.Contains(headers, "Date: Thu, 02 Jan 2020 03:04:05 +0000"+CRLF) {
+		t.Fatal("Unexpected date header:", headers)
+	}
+}
+
+// TestRenderHeadersBody test if the rendered headers and body equal the written message
+func TestRenderHeadersBody(t *testing.T) {
+	envelope := Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Subject:     "hello world",
+		Parts:       NewAlternative("hello world", "<p>hello world</p>"),
+		Attachments: []*File{AttachBytes("report.csv", "text/csv", []byte("id,name\n"))},
+	}
+
+	headers, body, err := envelope.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasSuffix(headers, []byte(CRLF+CRLF)) || bytes.Contains(headers[:len(headers)-len(CRLF)], []byte(CRLF+CRLF)) {
+		t.Fatalf("Unexpected header block: %q", headers)
+	}
+
+	message := append(append([]byte(nil), headers...), body...)
+	if structure(t, string(message)) != "multipart/mixed(multipart/alternative(text/plain,text/html),text/csv)" {
+		t.Fatal("Unexpected MIME tree:", structure(t, string(message)))
+	}
+
+	_, split, err := splitMessage(message)
+	if err != nil || !bytes.Equal(split, body) {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+
+	envelope.BoundaryFunc = func() string { return "boundary" }
+
+	headers, err = envelope.RenderHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err = envelope.RenderBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := envelope.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(headers)+string(body) != string(expected) {
+		t.Fatalf("Unexpected rendered message: %q", string(headers)+string(body))
+	}
+
+	envelope.LineEnding = "\n"
+
+	headers, body, err = envelope.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasSuffix(headers, []byte("\n\n")) || !bytes.HasPrefix(body, []byte("--boundary\n")) {
+		t.Fatalf("Unexpected rendered message: %q %q", headers, body)
+	}
+}
+
+// generator generates the given amount of bytes without holding them in
+// memory and records the amount of bytes read
+type generator struct {
+	size int64
+	read int64
+}
+
+func (g *generator) Read(p []byte) (int, error) {
+	if g.read >= g.size {
+		return 0, io.EOF
+	}
+
+	if remaining := g.size - g.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	for index := range p {
+		p[index] = byte(g.read + int64(index))
+	}
+
+	g.read += int64(len(p))
+	return len(p), nil
+}
+
+// streamProbe records the amount of bytes read from the generator once the
+// given threshold of output has been written
+type streamProbe struct {
+	source    *generator
+	threshold int64
+	written   int64
+	largest   int
+	read      int64
+}
+
+func (p *streamProbe) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if len(b) > p.largest {
+		p.largest = len(b)
+	}
+
+	if p.read == 0 && p.written >= p.threshold {
+		p.read = p.source.read
+	}
+
+	return len(b), nil
+}
+
+// TestWritingPartHeaders test if custom part headers are only written to the intended part
+func TestWritingPartHeaders(t *testing.T) {
+	parts := NewAlternative("hello world", "<p>hallo wereld</p>")
+	parts[1].Headers = Headers{
+		"content-language":          {"nl"},
+		"Content-Type":              {"text/plain"},
+		"Content-Transfer-Encoding": {"8bit"},
+	}
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: parts,
+	}
+
+	output, err := capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(output, "Content-Language") != 1 {
+		t.Fatal("Unexpected amount of Content-Language headers:", output)
+	}
+
+	html := output[strings.Index(output, "Content-Language"):]
+	html = html[:strings.Index(html, CRLF+CRLF)]
+
+	if !strings.Contains(html, "Content-Type: text/html; charset=utf-8") || !strings.Contains(html, "Content-Transfer-Encoding: quoted-printable") {
+		t.Fatal("Unexpected html part headers:", html)
+	}
+
+	parts[0].Headers = Headers{"X-Injected": {"hello\r\nBcc: attacker@evil.com"}}
+	parts[0].Reader = strings.NewReader("hello world")
+	parts[1].Reader = strings.NewReader("<p>hallo wereld</p>")
+
+	_, err = capture(&envelope)
+	if !errors.Is(err, ErrInvalidHeaderValue) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestWritingMIMEVersion test if MIME-Version is only written once inside the message headers
+func TestWritingMIMEVersion(t *testing.T) {
+	file := func(name string) *File {
+		return &File{
+			Name: name,
+			Header: map[string][]string{
+				"MIME-Version": {"1.0"},
+			},
+			CopyFunc: func(w io.Writer) error {
+				_, err := io.WriteString(w, "hello world")
+				return err
+			},
+		}
+	}
+
+	envelope := Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Parts:       NewAlternative("hello world", "<p>hello world</p>"),
+		Embedded:    []*File{file("logo.png")},
+		Attachments: []*File{file("report.pdf"), file("invoice.pdf")},
+	}
+
+	output, err := capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := strings.Count(output, "MIME-Version"); count != 1 {
+		t.Fatal("Unexpected amount of MIME-Version headers:", count)
+	}
+
+	headers := output[:strings.Index(output, CRLF+CRLF)]
+	if !strings.Contains(headers, "MIME-Version: 1.0"+CRLF) {
+		t.Fatal("MIME-Version not found inside the message headers:", headers)
+	}
+}
+
+// TestWritingStreamingAttachment test if large attachments are encoded while being read
+func TestWritingStreamingAttachment(t *testing.T) {
+	source := &generator{size: 16 << 20}
+	probe := &streamProbe{source: source, threshold: 1 << 20}
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Attachments: []*File{
+			{
+				Name: "large.bin",
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.Copy(w, source)
+					return err
+				},
+			},
+		},
+	}
+
+	err := envelope.Write(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if source.read != source.size {
+		t.Fatal("Unexpected amount of bytes read:", source.read)
+	}
+
+	// Once 1MB of output has been written no more than a few read buffers
+	// should have been consumed from the source
+	if probe.read == 0 || probe.read > 2<<20 {
+		t.Fatal("Attachment has been buffered before writing:", probe.read)
+	}
+
+	if probe.largest > 64<<10 {
+		t.Fatal("Unexpected large write:", probe.largest)
+	}
+}
+
+// BenchmarkWritingAttachment benchmarks writing a message containing a large attachment
+func BenchmarkWritingAttachment(b *testing.B) {
+	const size = 8 << 20
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+
+	for index := 0; index < b.N; index++ {
+		envelope := Envelope{
+			From:  "john@example.com",
+			To:    []string{"boss@example.com"},
+			Parts: text("hello world"),
+			Attachments: []*File{
+				{
+					Name: "large.bin",
+					CopyFunc: func(w io.Writer) error {
+						_, err := io.Copy(w, &generator{size: size})
+						return err
+					},
+				},
+			},
+		}
+
+		err := envelope.Write(io.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWritingMessages measures the allocations made while rendering
+// messages inside a bulk send loop (ex: go test -run - -bench
+// WritingMessages -benchtime 1000x)
+func BenchmarkWritingMessages(b *testing.B) {
+	content := bytes.Repeat([]byte("hello world "), 1024)
+
+	b.ReportAllocs()
+
+	for index := 0; index < b.N; index++ {
+		envelope := Envelope{
+			Date:         time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC),
+			MessageID:    "<1@example.com>",
+			From:         "john@example.com",
+			To:           []string{"boss@example.com"},
+			Subject:      "hello world",
+			BoundaryFunc: func() string { return "boundary" },
+			Parts: []*Part{
+				{ContentType: "text/plain", Encoding: Base64, Reader: bytes.NewReader(content)},
+				{ContentType: "text/html", Encoding: Base64, Reader: bytes.NewReader(content)},
+			},
+			Attachments: []*File{
+				AttachBytes("first.txt", "text/plain", content),
+				AttachBytes("second.txt", "text/plain", content),
+			},
+		}
+
+		err := envelope.Write(io.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestWritingReaderFunc test if envelopes using reader funcs could be rendered multiple times
+func TestWritingReaderFunc(t *testing.T) {
+	envelope := Envelope{
+		From:         "john@example.com",
+		To:           []string{"boss@example.com"},
+		BoundaryFunc: func() string { return "boundary" },
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("ignored"),
+				ReaderFunc: func() io.Reader {
+					return strings.NewReader("hello world")
+				},
+			},
+		},
+		Attachments: []*File{
+			{
+				Name: "report.pdf",
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.WriteString(w, "%PDF-1.4")
+					return err
+				},
+			},
+		},
+	}
+
+	first, err := capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(first, "hello world") || strings.Contains(first, "ignored") {
+		t.Fatal("Unexpected content:", first)
+	}
+
+	if first != second {
+		t.Fatal("Unexpected second render:", second)
+	}
+}
+
+// TestWritingReplyTo test if multiple Reply-To addresses are written as comma separated list
+func TestWritingReplyTo(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com", "Dan <dan@example.com>"},
+		ReplyTo: []string{"support@example.com", "Jürgen <jurgen@example.com>"},
+		Parts:   text("hello world"),
+	}
+
+	headers, output := render(t, &envelope)
+
+	expected := []string{
+		"To: boss@example.com, Dan <dan@example.com>",
+		"Reply-To: support@example.com, =?utf-8?q?J=C3=BCrgen?= <jurgen@example.com>",
+	}
+
+	for _, header := range expected {
+		if !strings.Contains(headers, header+CRLF) {
+			t.Fatal("Expected header not found:", header, headers)
+		}
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := parsed.Header.AddressList("Reply-To")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[1].Name != "Jürgen" {
+		t.Fatal("Unexpected Reply-To addresses:", list)
+	}
+}
+
+// TestWritingCompressedFile test if compressed files are smaller and could be decompressed
+func TestWritingCompressedFile(t *testing.T) {
+	content := strings.Repeat("2021-05-03 09:04:05 INFO request handled\n", 1000)
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Attachments: []*File{
+			{
+				Name:     "app.log",
+				Header:   map[string][]string{"Content-Type": {"text/plain"}},
+				Compress: true,
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.WriteString(w, content)
+					return err
+				},
+			},
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	reader.NextPart()
+
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if part.Header.Get("Content-Type") != "application/gzip" || part.FileName() != "app.log.gz" {
+		t.Fatal("Unexpected attachment headers:", part.Header)
+	}
+
+	compressed, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(compressed) >= len(content) {
+		t.Fatal("Content has not been compressed:", len(compressed))
+	}
+
+	decompressor, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decompressed, err := io.ReadAll(decompressor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decompressed) != content {
+		t.Fatal("Unexpected decompressed content")
+	}
+}
+
+// TestWritingFailingReader test if reader errors are wrapped with the failing part
+func TestWritingFailingReader(t *testing.T) {
+	failure := errors.New("connection reset")
+
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"boss@example.com"},
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("hello world"),
+			},
+			{
+				ContentType: "text/html",
+				Encoding:    QuotedPrintable,
+				Reader:      io.MultiReader(strings.NewReader("<p>hello"), iotest.ErrReader(failure)),
+			},
+		},
+	}
+
+	_, err := capture(&envelope)
+	if !errors.Is(err, failure) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if err.Error() != "writing part 2 (text/html): connection reset" {
+		t.Fatal("Unexpected error message:", err)
+	}
+
+	envelope.Parts = text("hello world")
+	envelope.Attachments = []*File{
+		{
+			Name: "report.pdf",
+			CopyFunc: func(w io.Writer) error {
+				return failure
+			},
+		},
+	}
+
+	_, err = capture(&envelope)
+	if err == nil || err.Error() != "writing attachment 1 (report.pdf): connection reset" {
+		t.Fatal("Unexpected error message:", err)
+	}
+}
+
+// TestWritingBoundaryCollision test if unencoded content containing a boundary delimiter is rejected
+func TestWritingBoundaryCollision(t *testing.T) {
+	identifiers := []string{"outer", "inner"}
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"boss@example.com"},
+		BoundaryFunc: func() string {
+			identifier := identifiers[0]
+			identifiers = append(identifiers[1:], identifier)
+			return identifier
+		},
+		Parts: []*Part{
+			{ContentType: "text/plain", Encoding: Unencoded, Reader: strings.NewReader("hello --outer world" + CRLF + " --inner" + CRLF)},
+			{ContentType: "text/html", Encoding: Unencoded, Reader: strings.NewReader("<p>hello world</p>")},
+		},
+		Attachments: []*File{AttachBytes("report.csv", "text/csv", []byte("id,name\n"))},
+	}
+
+	_, output := render(t, &envelope)
+	if structure(t, output) != "multipart/mixed(multipart/alternative(text/plain,text/html),text/csv)" {
+		t.Fatal("Unexpected MIME tree:", structure(t, output))
+	}
+
+	parsed, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := io.ReadAll(parsed.Parts[0].ReaderFunc())
+	if err != nil || string(content) != "hello --outer world"+CRLF+" --inner"+CRLF {
+		t.Fatalf("Unexpected content: %q", content)
+	}
+
+	bodies := []string{
+		"hello world" + CRLF + "--inner" + CRLF,
+		"hello world" + CRLF + "--outer--" + CRLF,
+		"--outer",
+	}
+
+	for _, body := range bodies {
+		envelope.Parts[0].Reader = iotest.OneByteReader(strings.NewReader(body))
+
+		_, err = capture(&envelope)
+		if !errors.Is(err, ErrBoundaryCollision) {
+			t.Fatalf("Unexpected error for %q: %v", body, err)
+		}
+	}
+}
+
+// TestWritingPartialCopy test if the content written before a CopyFunc failure is flushed
+func TestWritingPartialCopy(t *testing.T) {
+	failure := errors.New("connection reset")
+	content := bytes.Repeat([]byte("a"), 100)
+
+	for _, compress := range []bool{false, true} {
+		file := File{
+			Name:     "report.csv",
+			Compress: compress,
+			CopyFunc: func(w io.Writer) error {
+				w.Write(content)
+				return failure
+			},
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err := file.Write(buffer, Attachment)
+		if !errors.Is(err, failure) {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		sections := strings.SplitN(buffer.String(), CRLF+CRLF, 2)
+		encoded := strings.ReplaceAll(strings.TrimSuffix(sections[1], CRLF), CRLF, "")
+
+		if len(encoded)%4 != 0 {
+			t.Fatal("Unexpected unflushed content:", encoded)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if compress {
+			reader, err := gzip.NewReader(bytes.NewReader(decoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err = io.ReadAll(reader)
+			if err != nil {
+				t.Fatal("Unexpected unclosed compressor:", err)
+			}
+		}
+
+		if !bytes.Equal(decoded, content) {
+			t.Fatalf("Unexpected content: %q", decoded)
+		}
+	}
+}
+
+// update rewrites the golden files inside the testdata directory. The golden
+// message could be regenerated after an intended formatting change by running:
+// go test -run TestWritingGolden -update
+var update = flag.Bool("update", false, "update the golden files")
+
+//go:embed testdata/envelope.golden
+var golden string
+
+// TestWritingGolden test if the rendered message matches the golden message byte for byte
+func TestWritingGolden(t *testing.T) {
+	counter := 0
+
+	envelope := Envelope{
+		Date:      time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC),
+		MessageID: "<1@example.com>",
+		From:      "John Doe <john@example.com>",
+		ReplyTo:   []string{"support@example.com"},
+		To:        []string{"boss@example.com", "Jöhn Smith <smith@example.com>"},
+		Cc:        []string{"team@example.com"},
+		Bcc:       []string{"audit@example.com"},
+		Subject:   "Quarterly report ✔",
+		Priority:  HighPriority,
+		Headers: Headers{
+			"x-mailer": {"postbox"},
+		},
+		BoundaryFunc: func() string {
+			counter++
+			return fmt.Sprintf("boundary-%d", counter)
+		},
+		Parts: NewAlternative(
+			"Hello,\n\nPlease find the quarterly report attached. The numbers look better than expected, well done everyone!",
+			`<p>Hello,</p><p>Please find the quarterly report attached.</p><img src="cid:logo.png">`,
+		),
+		Embedded: []*File{
+			{
+				Name:   "logo.png",
+				Header: map[string][]string{"Content-Type": {"image/png"}},
+				CopyFunc: func(w io.Writer) error {
+					_, err := w.Write([]byte("\x89PNG\r\n\x1a\n"))
+					return err
+				},
+			},
+		},
+		Attachments: []*File{
+			{
+				Name:   "report.csv",
+				Header: map[string][]string{"Content-Type": {"text/csv"}},
+				CopyFunc: func(w io.Writer) error {
+					_, err := io.WriteString(w, "quarter,revenue\nQ1,100\nQ2,120\n")
+					return err
+				},
+			},
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		err = os.WriteFile("testdata/envelope.golden", []byte(output), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	if output != golden {
+		t.Fatalf("Unexpected output, run with -update when the change is intended:\n%s", output)
+	}
+}
+
+// TestWritingLineEnding test if all CRLF line endings are replaced when rendering with LF
+func TestWritingLineEnding(t *testing.T) {
+	envelope := Envelope{
+		From:       "john@example.com",
+		To:         []string{"boss@example.com"},
+		Subject:    "hello world",
+		LineEnding: LF,
+		Parts: []*Part{
+			{ContentType: "text/plain", Encoding: QuotedPrintable, Reader: strings.NewReader(strings.Repeat("hello world ", 20) + CRLF + "bye")},
+			{ContentType: "text/html", Encoding: Unencoded, Reader: strings.NewReader("<p>hello</p>" + CRLF + "<p>world</p>")},
+		},
+		Attachments: []*File{
+			AttachBytes("data.bin", "application/octet-stream", bytes.Repeat([]byte{'\r', '\n', 0xff}, 100)),
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(output, CR) {
+		t.Fatalf("Unexpected CR inside output: %q", output)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Header.Get("Subject") != "hello world" {
+		t.Fatal("Unexpected subject:", parsed.Header.Get("Subject"))
+	}
+
+	result := structure(t, output)
+	if result != "multipart/mixed(multipart/alternative(text/plain,text/html),application/octet-stream)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	if !strings.Contains(output, "<p>hello</p>"+LF+"<p>world</p>"+LF) {
+		t.Fatal("Unexpected html part:", output)
+	}
+}
+
+// TestEnvelopeAdd test if repeated calls accumulate recipients and attachments
+func TestEnvelopeAdd(t *testing.T) {
+	envelope := Envelope{}
+
+	for _, address := range []string{"boss@example.com", "jane@example.com"} {
+		envelope.AddTo(address)
+	}
+
+	envelope.AddTo()
+	envelope.AddCc("team@example.com")
+	envelope.AddCc("sales@example.com", "support@example.com")
+	envelope.AddBcc("audit@example.com")
+
+	envelope.AddAttachment(AttachBytes("first.txt", "text/plain", []byte("first")))
+	envelope.AddAttachment(AttachBytes("second.txt", "text/plain", []byte("second")))
+
+	if strings.Join(envelope.To, ",") != "boss@example.com,jane@example.com" {
+		t.Fatal("Unexpected To recipients:", envelope.To)
+	}
+
+	if strings.Join(envelope.Cc, ",") != "team@example.com,sales@example.com,support@example.com" {
+		t.Fatal("Unexpected Cc recipients:", envelope.Cc)
+	}
+
+	if strings.Join(envelope.Bcc, ",") != "audit@example.com" {
+		t.Fatal("Unexpected Bcc recipients:", envelope.Bcc)
+	}
+
+	if len(envelope.Attachments) != 2 || envelope.Attachments[0].Name != "first.txt" || envelope.Attachments[1].Name != "second.txt" {
+		t.Fatal("Unexpected attachments:", envelope.Attachments)
+	}
+}
+
+// TestWritingLongLines test if lines exceeding the line limit of unencoded parts are rejected or re-encoded
+func TestWritingLongLines(t *testing.T) {
+	body := strings.Repeat("x", 2000)
+
+	part := Part{
+		ContentType: "text/plain",
+		Encoding:    Unencoded,
+		Reader:      strings.NewReader(body),
+	}
+
+	err := part.Write(io.Discard, "")
+	if !errors.Is(err, ErrLineTooLong) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	part = Part{
+		ContentType: "text/plain",
+		Encoding:    Unencoded,
+		Reader:      strings.NewReader(body),
+		LongLines:   EncodeLongLines,
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = part.Write(buffer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := buffer.String()
+	if !strings.Contains(output, "Content-Transfer-Encoding: quoted-printable"+CRLF) {
+		t.Fatal("Unexpected encoding:", output)
+	}
+
+	content := output[strings.Index(output, CRLF+CRLF)+len(CRLF+CRLF):]
+	for _, line := range strings.Split(content, CRLF) {
+		if len(line) > MaxLineLength {
+			t.Fatal("Unexpected line length:", len(line))
+		}
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSuffix(string(decoded), CRLF) != body {
+		t.Fatal("Unexpected decoded content:", len(decoded))
+	}
+
+	for _, longLines := range []LongLines{RejectLongLines, EncodeLongLines} {
+		part = Part{
+			ContentType: "text/plain",
+			Encoding:    SevenBit,
+			Reader:      strings.NewReader(strings.Repeat(strings.Repeat("x", 998)+CRLF, 3)),
+			LongLines:   longLines,
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err = part.Write(buffer, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(buffer.String(), "Content-Transfer-Encoding: 7bit"+CRLF) {
+			t.Fatal("Unexpected encoding:", buffer.String())
+		}
+	}
+}
+
+// TestWritingLineLength test if base64 content is wrapped at the configured line length
+func TestWritingLineLength(t *testing.T) {
+	content := bytes.Repeat([]byte("hello world "), 100)
+
+	envelope := Envelope{
+		From:         "john@example.com",
+		To:           []string{"boss@example.com"},
+		LineLength:   64,
+		BoundaryFunc: func() string { return "boundary" },
+		Parts: []*Part{
+			{ContentType: "text/plain", Encoding: Base64, Reader: bytes.NewReader(content)},
+			{ContentType: "text/html", Encoding: Base64, Reader: bytes.NewReader(content), LineLength: 40},
+		},
+		Attachments: []*File{
+			AttachBytes("hello.txt", "text/plain", content),
+		},
+	}
+
+	size, err := envelope.EstimatedSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != int64(len(output)) {
+		t.Fatal("Unexpected estimated size:", size, len(output))
+	}
+
+	sections := []string{}
+	for _, section := range strings.Split(output, "--boundary") {
+		if strings.Contains(section, "Content-Transfer-Encoding: base64") {
+			sections = append(sections, section)
+		}
+	}
+
+	expected := []int{64, 40, 64}
+	if len(sections) != len(expected) {
+		t.Fatal("Unexpected amount of base64 sections:", len(sections))
+	}
+
+	for index, length := range expected {
+		section := sections[index]
+		body := section[strings.Index(section, CRLF+CRLF)+len(CRLF+CRLF):]
+		lines := strings.Split(strings.TrimSpace(body), CRLF)
+
+		if len(lines[0]) != length {
+			t.Fatal("Unexpected line length:", len(lines[0]), "expected:", length)
+		}
+
+		for _, line := range lines {
+			if len(line) > length {
+				t.Fatal("Unexpected line length:", len(line), "expected:", length)
+			}
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decoded, content) {
+			t.Fatal("Unexpected decoded content")
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package postbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// parsedHeaders contains the message headers which are mapped onto envelope
+// fields and therefore not included inside the envelope custom headers
+var parsedHeaders = []string{
+	"Return-Path",
+	"Date",
+	"From",
+	"Sender",
+	"Reply-To",
+	"To",
+	"Cc",
+	"Bcc",
+	"Subject",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+	"MIME-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// Parse reads a raw RFC 5322 message from the given reader and constructs a
+// new envelope. Encoded-words inside the address and subject fields are
+// decoded. Entities with an attachment disposition are parsed as
+// attachments, entities with a Content-ID as embedded files and all other
+// entities as parts. The decoded content of all entities is kept in memory.
+func Parse(reader io.Reader) (*Envelope, error) {
+	message, err := mail.ReadMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	header := message.Header
+	envelope := &Envelope{
+		MessageID:  strings.TrimSpace(header.Get("Message-ID")),
+		InReplyTo:  strings.TrimSpace(header.Get("In-Reply-To")),
+		References: strings.Fields(header.Get("References")),
+		ReturnPath: strings.Trim(strings.TrimSpace(header.Get("Return-Path")), "<>"),
+	}
+
+	if header.Get("Date") != "" {
+		envelope.Date, err = header.Date()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	decoder := mime.WordDecoder{}
+	envelope.Subject, err = decoder.DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := parseAddresses(header, "From")
+	if err != nil {
+		return nil, err
+	}
+
+	envelope.From = strings.Join(from, ", ")
+
+	sender, err := parseAddresses(header, "Sender")
+	if err != nil {
+		return nil, err
+	}
+
+	envelope.Sender = strings.Join(sender, ", ")
+
+	lists := map[string]*[]string{
+		"Reply-To": &envelope.ReplyTo,
+		"To":       &envelope.To,
+		"Cc":       &envelope.Cc,
+		"Bcc":      &envelope.Bcc,
+	}
+
+	for key, list := range lists {
+		*list, err = parseAddresses(header, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for property, values := range header {
+		// Content headers describe the root entity and are kept by the
+		// parsed part, file or boundary
+		if parsed(property) || strings.HasPrefix(strings.ToLower(property), "content-") {
+			continue
+		}
+
+		if envelope.Headers == nil {
+			envelope.Headers = Headers{}
+		}
+
+		envelope.Headers[canonicalKey(property)] = values
+	}
+
+	err = walkEntity(textproto.MIMEHeader(header), message.Body, true, envelope.parseEntity)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope, nil
+}
+
+// MessagePart represents a leaf entity of a streamed message
+type MessagePart struct {
+	Header    textproto.MIMEHeader
+	MediaType string            // lower-case media type (ex: text/plain)
+	Params    map[string]string // Content-Type parameters (ex: charset)
+	Encoding  Encoding
+
+	// Body contains the decoded content of the part. The body is only valid
+	// during the callback, unread content is skipped once the callback
+	// returns.
+	Body io.Reader
+
+	root bool // the part is the message itself (ex: a single part message)
+}
+
+// ReadMessage reads a raw RFC 5322 message from the given reader and calls the
+// given function for every leaf entity in order of appearance. Nested
+// multipart entities are walked depth-first. The part bodies are streamed
+// from the reader and never kept in memory, making it suited for large
+// messages. The message headers are returned once all parts have been read.
+// An error returned by the given function stops reading and is returned as
+// is.
+func ReadMessage(reader io.Reader, fn func(part *MessagePart) error) (mail.Header, error) {
+	message, err := mail.ReadMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkEntity(textproto.MIMEHeader(message.Header), message.Body, true, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return message.Header, nil
+}
+
+// walkEntity walks the given MIME entity and calls the given function for all
+// leaf entities. The root entity is terminated with a CRLF which is not
+// considered part of the content.
+func walkEntity(header textproto.MIMEHeader, body io.Reader, root bool, fn func(part *MessagePart) error) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	media, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(media, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return fmt.Errorf("%w: %s has no boundary", ErrMalformedMessage, media)
+		}
+
+		reader := multipart.NewReader(body, boundary)
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			err = walkEntity(part.Header, part, false, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	encoding := Encoding(strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))))
+	if encoding == "" {
+		encoding = SevenBit
+	}
+
+	err = encoding.Validate()
+	if err != nil {
+		return err
+	}
+
+	content := decode(body, encoding)
+	if root && encoding != Base64 {
+		content = &trimReader{reader: bufio.NewReader(content), suffix: crlf}
+	}
+
+	part := &MessagePart{
+		Header:    header,
+		MediaType: media,
+		Params:    params,
+		Encoding:  encoding,
+		Body:      content,
+		root:      root,
+	}
+
+	return fn(part)
+}
+
+// trimReader strips the given suffix from the end of the content
+type trimReader struct {
+	reader *bufio.Reader
+	suffix []byte
+}
+
+func (t *trimReader) Read(p []byte) (int, error) {
+	// At least one byte more than the suffix is buffered, ensuring that the
+	// suffix is never returned when it terminates the content
+	buffered, err := t.reader.Peek(len(t.suffix) + 1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	available := t.reader.Buffered()
+	if err == io.EOF {
+		if bytes.HasSuffix(buffered, t.suffix) {
+			available -= len(t.suffix)
+		}
+
+		if available == 0 {
+			return 0, io.EOF
+		}
+	} else {
+		available -= len(t.suffix)
+	}
+
+	if len(p) > available {
+		p = p[:available]
+	}
+
+	return t.reader.Read(p)
+}
+
+// parsed checks whether the given header is mapped onto an envelope field
+func parsed(property string) bool {
+	for _, key := range parsedHeaders {
+		if strings.EqualFold(key, property) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseAddresses parses the address list of the given header. The addresses
+// are formatted with decoded display names.
+func parseAddresses(header mail.Header, key string) ([]string, error) {
+	if header.Get(key) == "" {
+		return nil, nil
+	}
+
+	list, err := header.AddressList(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	result := make([]string, len(list))
+	for index, address := range list {
+		result[index] = address.Address
+		if address.Name != "" {
+			result[index] = quote(address.Name) + " <" + address.Address + ">"
+		}
+	}
+
+	return result, nil
+}
+
+// parseEntity appends the given leaf entity to the envelope parts, embedded
+// files or attachments. The decoded content of the entity is kept in memory.
+func (e *Envelope) parseEntity(leaf *MessagePart) error {
+	content, err := io.ReadAll(leaf.Body)
+	if err != nil {
+		return err
+	}
+
+	header := leaf.Header
+	media := leaf.MediaType
+	params := leaf.Params
+	encoding := leaf.Encoding
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	contentID := strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>")
+
+	if Disposition(disposition) == Attachment || contentID != "" {
+		file := &File{
+			Name:      filename,
+			ContentID: contentID,
+			Header: map[string][]string{
+				"Content-Type": {contentType},
+			},
+			CopyFunc: func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			},
+		}
+
+		if Disposition(disposition) == Attachment {
+			e.Attachments = append(e.Attachments, file)
+			return nil
+		}
+
+		e.Embedded = append(e.Embedded, file)
+		return nil
+	}
+
+	part := &Part{
+		ContentType: media,
+		Encoding:    encoding,
+		ReaderFunc: func() io.Reader {
+			return bytes.NewReader(content)
+		},
+		Charset:     params["charset"],
+		Disposition: Disposition(disposition),
+		Filename:    filename,
+	}
+
+	for property, values := range header {
+		switch canonicalKey(property) {
+		case "Content-Type", "Content-Transfer-Encoding", "Content-Disposition":
+			continue
+		}
+
+		// The header of a single part message contains the message headers
+		// as well, only the content headers describe the part
+		if leaf.root && !strings.HasPrefix(strings.ToLower(property), "content-") {
+			continue
+		}
+
+		if part.Headers == nil {
+			part.Headers = Headers{}
+		}
+
+		part.Headers[canonicalKey(property)] = values
+	}
+
+	e.Parts = append(e.Parts, part)
+	return nil
+}
+
+// decode returns a reader decoding the given content transfer encoding
+func decode(reader io.Reader, encoding Encoding) io.Reader {
+	switch encoding {
+	case QuotedPrintable:
+		return quotedprintable.NewReader(reader)
+	case Base64:
+		return base64.NewDecoder(base64.StdEncoding, reader)
+	default:
+		return reader
+	}
+}
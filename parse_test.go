@@ -0,0 +1,273 @@
+package postbox
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// TestParse test if a rendered envelope could be parsed back into an envelope
+func TestParse(t *testing.T) {
+	pdf := []byte("%PDF-1.4 hello world")
+	file := func(name string, content []byte) *File {
+		return &File{
+			Name: name,
+			Header: map[string][]string{
+				"Content-Type": {"application/pdf"},
+			},
+			CopyFunc: func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			},
+		}
+	}
+
+	original := Envelope{
+		Date:        time.Date(2021, 5, 3, 9, 4, 5, 0, time.UTC),
+		From:        "Jürgen <jurgen@example.com>",
+		ReplyTo:     []string{"support@example.com", "sales@example.com"},
+		To:          []string{"boss@example.com", `"Dan Doe" <dan@example.com>`},
+		Cc:          []string{"jane@example.com"},
+		Subject:     "Rückmeldung über Ihr Konto",
+		MessageID:   "<1@example.com>",
+		InReplyTo:   "<0@example.com>",
+		References:  []string{"<0@example.com>"},
+		Headers:     Headers{"X-Mailer": {"postbox"}},
+		Parts:       NewAlternative("Grüße = hello", "<p>Grüße</p>"),
+		Embedded:    []*File{{Name: "logo.png", ContentID: "logo", CopyFunc: func(w io.Writer) error { _, err := w.Write([]byte{1, 2, 3}); return err }}},
+		Attachments: []*File{file("report.pdf", pdf)},
+	}
+
+	message, err := original.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !parsed.Date.Equal(original.Date) {
+		t.Fatal("Unexpected date:", parsed.Date)
+	}
+
+	if parsed.From != `"Jürgen" <jurgen@example.com>` || strings.Join(parsed.ReplyTo, ",") != strings.Join(original.ReplyTo, ",") {
+		t.Fatal("Unexpected addresses:", parsed.From, parsed.ReplyTo)
+	}
+
+	if strings.Join(parsed.To, ",") != strings.Join(original.To, ",") || strings.Join(parsed.Cc, ",") != "jane@example.com" {
+		t.Fatal("Unexpected recipients:", parsed.To, parsed.Cc)
+	}
+
+	if parsed.Subject != original.Subject || parsed.MessageID != original.MessageID || parsed.InReplyTo != original.InReplyTo {
+		t.Fatal("Unexpected fields:", parsed.Subject, parsed.MessageID, parsed.InReplyTo)
+	}
+
+	if len(parsed.References) != 1 || parsed.References[0] != "<0@example.com>" {
+		t.Fatal("Unexpected references:", parsed.References)
+	}
+
+	if parsed.Headers["X-Mailer"][0] != "postbox" {
+		t.Fatal("Unexpected custom headers:", parsed.Headers)
+	}
+
+	if len(parsed.Parts) != 2 || len(parsed.Embedded) != 1 || len(parsed.Attachments) != 1 {
+		t.Fatal("Unexpected entities:", len(parsed.Parts), len(parsed.Embedded), len(parsed.Attachments))
+	}
+
+	expected := map[string]string{"text/plain": "Grüße = hello", "text/html": "<p>Grüße</p>"}
+	for _, part := range parsed.Parts {
+		content, err := io.ReadAll(part.ReaderFunc())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != expected[part.ContentType] || part.Encoding != QuotedPrintable || part.Charset != DefaultCharset {
+			t.Fatal("Unexpected part:", part.ContentType, part.Encoding, part.Charset, string(content))
+		}
+	}
+
+	if parsed.Embedded[0].ID() != "logo" || parsed.Attachments[0].Name != "report.pdf" {
+		t.Fatal("Unexpected files:", parsed.Embedded[0].ID(), parsed.Attachments[0].Name)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = parsed.Attachments[0].CopyFunc(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buffer.Bytes(), pdf) {
+		t.Fatal("Unexpected attachment content:", buffer.String())
+	}
+
+	output, err := parsed.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if structure(t, output) != structure(t, string(message)) {
+		t.Fatal("Unexpected structure:", structure(t, output))
+	}
+}
+
+// TestParseSinglePart test if the content of a single part message is parsed
+func TestParseSinglePart(t *testing.T) {
+	message := "From: john@example.com" + CRLF +
+		"To: boss@example.com" + CRLF +
+		"Subject: =?utf-8?q?caf=C3=A9?=" + CRLF +
+		"Content-Language: en" + CRLF +
+		CRLF +
+		"hello world" + CRLF
+
+	parsed, err := Parse(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Subject != "café" || len(parsed.Parts) != 1 {
+		t.Fatal("Unexpected envelope:", parsed.Subject, parsed.Parts)
+	}
+
+	part := parsed.Parts[0]
+	content, err := io.ReadAll(part.ReaderFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if part.ContentType != "text/plain" || part.Encoding != SevenBit || string(content) != "hello world" {
+		t.Fatal("Unexpected part:", part.ContentType, part.Encoding, string(content))
+	}
+
+	// Content headers of the root entity are kept by the part
+	if len(parsed.Headers) != 0 {
+		t.Fatal("Unexpected message headers:", parsed.Headers)
+	}
+
+	_, err = parsed.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestParseSinglePartRoundTrip test if the message headers of a single part message are not duplicated once written again
+func TestParseSinglePartRoundTrip(t *testing.T) {
+	original := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Headers: Headers{"X-Mailer": {"postbox"}},
+		Parts: []*Part{
+			{ContentType: "text/plain", Encoding: QuotedPrintable, Language: "en", Reader: strings.NewReader("hello world")},
+		},
+	}
+
+	message, err := original.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 2; index++ {
+		parsed, err := Parse(bytes.NewReader(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		headers := parsed.Parts[0].Headers
+		if len(headers) != 1 || headers["Content-Language"][0] != "en" {
+			t.Fatal("Unexpected part headers:", headers)
+		}
+
+		message, err = parsed.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, header := range []string{"Subject: ", "From: ", "Message-ID: ", "MIME-Version: ", "X-Mailer: ", "Content-Language: "} {
+			if strings.Count(string(message), header) != 1 {
+				t.Fatal("Unexpected header count:", header, string(message))
+			}
+		}
+	}
+}
+
+// TestReadMessage test if the parts of a message are streamed in order and read lazily
+func TestReadMessage(t *testing.T) {
+	envelope := Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Subject:     "hello world",
+		Parts:       NewAlternative("hello world", "<p>hello world</p>"),
+		Attachments: []*File{AttachBytes("report.csv", "text/csv", bytes.Repeat([]byte("id,name\n"), 1000))},
+	}
+
+	message, err := envelope.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media := []string{}
+	contents := []string{}
+
+	header, err := ReadMessage(bytes.NewReader(message), func(part *MessagePart) error {
+		media = append(media, part.MediaType)
+
+		// The attachment body is only partially read, the remainder is skipped
+		if part.Encoding == Base64 {
+			buffer := make([]byte, 8)
+			_, err := io.ReadFull(part.Body, buffer)
+			contents = append(contents, string(buffer))
+			return err
+		}
+
+		content, err := io.ReadAll(part.Body)
+		contents = append(contents, string(content))
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("Subject") != "hello world" {
+		t.Fatal("Unexpected headers:", header)
+	}
+
+	if strings.Join(media, ",") != "text/plain,text/html,text/csv" {
+		t.Fatal("Unexpected parts:", media)
+	}
+
+	if strings.Join(contents, "|") != "hello world|<p>hello world</p>|id,name\n" {
+		t.Fatalf("Unexpected contents: %q", contents)
+	}
+
+	failure := io.ErrUnexpectedEOF
+	calls := 0
+
+	_, err = ReadMessage(bytes.NewReader(message), func(part *MessagePart) error {
+		calls++
+		return failure
+	})
+
+	if err != failure || calls != 1 {
+		t.Fatal("Unexpected error:", err, calls)
+	}
+
+	flat := "From: john@example.com" + CRLF + CRLF + "hello world" + CRLF + CRLF
+	_, err = ReadMessage(strings.NewReader(flat), func(part *MessagePart) error {
+		content, err := io.ReadAll(iotest.OneByteReader(part.Body))
+		if string(content) != "hello world"+CRLF {
+			t.Fatalf("Unexpected content: %q", content)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
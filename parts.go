@@ -0,0 +1,79 @@
+package postbox
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// NewAlternative constructs a quoted-printable text/plain and text/html part
+// which could be used as alternatives of the same content. The parts are
+// ordered from least to most preferred (plain text first) as defined in
+// RFC 2046.
+func NewAlternative(text string, html string) []*Part {
+	return []*Part{
+		{
+			ContentType: "text/plain",
+			Encoding:    QuotedPrintable,
+			ReaderFunc:  content(text),
+		},
+		{
+			ContentType: "text/html",
+			Encoding:    QuotedPrintable,
+			ReaderFunc:  content(html),
+		},
+	}
+}
+
+// NewCalendarPart constructs a base64 encoded text/calendar part containing
+// the given iCalendar (RFC 5545) content. The given iTIP method (ex: REQUEST,
+// CANCEL) is included as Content-Type parameter and has to match the METHOD
+// property inside the content.
+//
+// Outlook only recognizes the part as invite when the method parameter is
+// present and the part is written as alternative of the text body (ex:
+// append(NewAlternative(text, html), NewCalendarPart(ics, "REQUEST"))). Some
+// clients additionally expect the content as application/ics attachment
+// (ex: invite.ics).
+func NewCalendarPart(ics string, method string) *Part {
+	return &Part{
+		ContentType: "text/calendar; method=" + strings.ToUpper(method),
+		Encoding:    Base64,
+		ReaderFunc:  content(ics),
+	}
+}
+
+// richness contains the rank of well known alternative media types ordered
+// from least to most preferred
+var richness = map[string]int{
+	"text/plain":    0,
+	"text/enriched": 1,
+	"text/html":     2,
+}
+
+// SortAlternatives sorts the given alternative parts in place from least to
+// most preferred (text/plain, text/enriched, text/html) as required by
+// RFC 2046 section 5.1.4. Clients display the last alternative they support.
+// Parts of other media types (ex: text/calendar) are moved after the well
+// known types, the relative order of parts of equal richness is kept.
+func SortAlternatives(parts []*Part) {
+	rank := func(part *Part) int {
+		media := strings.ToLower(strings.TrimSpace(strings.SplitN(part.ContentType, ";", 2)[0]))
+		if value, has := richness[media]; has {
+			return value
+		}
+
+		return len(richness)
+	}
+
+	sort.SliceStable(parts, func(i, j int) bool {
+		return rank(parts[i]) < rank(parts[j])
+	})
+}
+
+// content returns a reader func constructing a new reader for the given content
+func content(value string) func() io.Reader {
+	return func() io.Reader {
+		return strings.NewReader(value)
+	}
+}
@@ -0,0 +1,89 @@
+package postbox
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestNewAlternative test if the plain text part precedes the html part
+func TestNewAlternative(t *testing.T) {
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: NewAlternative("hello world", "<p>hello world</p>"),
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := structure(t, output)
+	if result != "multipart/alternative(text/plain,text/html)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	plain := strings.Index(output, "Content-Type: text/plain")
+	html := strings.Index(output, "Content-Type: text/html")
+
+	if plain < 0 || html < plain {
+		t.Fatal("text/plain does not precede text/html:", output)
+	}
+
+	if strings.Count(output, "Content-Transfer-Encoding: quoted-printable") != 2 {
+		t.Fatal("Unexpected encoding:", output)
+	}
+}
+
+// TestNewCalendarPart test if the calendar part contains the method parameter
+func TestNewCalendarPart(t *testing.T) {
+	ics := "BEGIN:VCALENDAR" + CRLF +
+		"METHOD:REQUEST" + CRLF +
+		"BEGIN:VEVENT" + CRLF +
+		"SUMMARY:hello world" + CRLF +
+		"END:VEVENT" + CRLF +
+		"END:VCALENDAR" + CRLF
+
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: append(NewAlternative("hello world", "<p>hello world</p>"), NewCalendarPart(ics, "request")),
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := structure(t, output)
+	if result != "multipart/alternative(text/plain,text/html,text/calendar)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	if !strings.Contains(output, "Content-Type: text/calendar; method=REQUEST; charset=utf-8"+CRLF) {
+		t.Fatal("Method parameter not found:", output)
+	}
+}
+
+// TestSortAlternatives test if the alternatives are ordered from least to most preferred
+func TestSortAlternatives(t *testing.T) {
+	parts := []*Part{
+		{ContentType: "text/calendar; method=REQUEST"},
+		{ContentType: "TEXT/HTML"},
+		{ContentType: "text/enriched"},
+		{ContentType: "application/json"},
+		{ContentType: "text/plain; format=flowed"},
+	}
+
+	SortAlternatives(parts)
+
+	result := make([]string, len(parts))
+	for index, part := range parts {
+		result[index] = part.ContentType
+	}
+
+	expected := "text/plain; format=flowed,text/enriched,TEXT/HTML,text/calendar; method=REQUEST,application/json"
+	if strings.Join(result, ",") != expected {
+		t.Fatal("Unexpected order:", result)
+	}
+}
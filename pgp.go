@@ -0,0 +1,141 @@
+package postbox
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ErrNoPGPRecipients is returned when a message is encrypted without recipients
+var ErrNoPGPRecipients = errors.New("message has no pgp recipients")
+
+// pgpConfig is used to sign and encrypt messages. SHA-256 is used as hash
+// algorithm which is announced through the micalg parameter.
+var pgpConfig = &packet.Config{
+	DefaultHash: crypto.SHA256,
+}
+
+// PGPSigner signs rendered messages with a detached OpenPGP signature as
+// defined in RFC 3156 section 5. The message content is wrapped inside a
+// multipart/signed entity containing the armored application/pgp-signature.
+// The transfer encoding restrictions of SMIMESigner apply.
+type PGPSigner struct {
+	Entity *openpgp.Entity // requires a decrypted private key
+}
+
+// Sign signs the given rendered message (ex: Envelope.Bytes) and returns the
+// multipart/signed message. The signed entity is constructed as done by
+// SMIMESigner.Sign.
+func (s *PGPSigner) Sign(message []byte) ([]byte, error) {
+	params := []string{`protocol="application/pgp-signature"`, "micalg=pgp-sha256"}
+	return sign(message, params, func(content []byte) (Headers, []byte, error) {
+		signature := bytes.NewBuffer(nil)
+		err := openpgp.ArmoredDetachSign(signature, s.Entity, bytes.NewReader(content), pgpConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		headers := Headers{
+			"Content-Type":        {"application/pgp-signature", `name="signature.asc"`},
+			"Content-Description": {"OpenPGP digital signature"},
+			"Content-Disposition": {string(Attachment), `filename="signature.asc"`},
+		}
+
+		return headers, canonicalLines(signature.Bytes()), nil
+	})
+}
+
+// PGPEncryptor encrypts rendered messages for the given recipients as defined
+// in RFC 3156 section 4. The message content is wrapped inside a
+// multipart/encrypted entity containing the application/pgp-encrypted control
+// part followed by the armored encrypted content.
+type PGPEncryptor struct {
+	Recipients []*openpgp.Entity
+
+	// Signer signs the encrypted content when set (RFC 3156 section 6.2), a
+	// decrypted private key is required
+	Signer *openpgp.Entity
+}
+
+// Encrypt encrypts the given rendered message (ex: Envelope.Bytes) and returns
+// the multipart/encrypted message. The Content headers of the message are
+// moved into the encrypted entity, all other headers are kept.
+// ErrNoPGPRecipients is returned when no recipients are configured.
+func (e *PGPEncryptor) Encrypt(message []byte) ([]byte, error) {
+	if len(e.Recipients) == 0 {
+		return nil, ErrNoPGPRecipients
+	}
+
+	headers, content, err := splitEntity(message)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := bytes.NewBuffer(nil)
+	armored, err := armor.Encode(encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openpgp.Encrypt(armored, e.Recipients, e.Signer, nil, pgpConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = plaintext.Write(content)
+	if err != nil {
+		return nil, err
+	}
+
+	err = plaintext.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = armored.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	identifier, err := GenerateBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	result := bytes.NewBuffer(nil)
+	result.Write(headers)
+
+	boundary := StartBoundary(result, identifier, "multipart/encrypted", `protocol="application/pgp-encrypted"`)
+	boundary.Mark()
+
+	control := Headers{
+		"Content-Type":        {"application/pgp-encrypted"},
+		"Content-Description": {"PGP/MIME version identification"},
+	}
+
+	control.Write(result)
+	result.WriteString(CRLF)
+	result.WriteString("Version: 1" + CRLF)
+	result.WriteString(CRLF)
+
+	boundary.Mark()
+
+	attachment := Headers{
+		"Content-Type":        {"application/octet-stream", `name="encrypted.asc"`},
+		"Content-Description": {"OpenPGP encrypted message"},
+		"Content-Disposition": {string(Inline), `filename="encrypted.asc"`},
+	}
+
+	attachment.Write(result)
+	result.WriteString(CRLF)
+	result.Write(canonicalLines(encrypted.Bytes()))
+	result.WriteString(CRLF)
+
+	boundary.End()
+
+	return result.Bytes(), nil
+}
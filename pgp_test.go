@@ -0,0 +1,181 @@
+package postbox
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// pgpEntity generates a new OpenPGP entity using a small RSA key
+func pgpEntity(t *testing.T) *openpgp.Entity {
+	result, err := openpgp.NewEntity("John Doe", "", "john@example.com", &packet.Config{RSABits: 1024, DefaultHash: crypto.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// pgpEnvelope renders a test message
+func pgpEnvelope(t *testing.T) []byte {
+	envelope := Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Parts:   NewAlternative("hello world", "<p>hello world</p>"),
+	}
+
+	message, err := envelope.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return message
+}
+
+// sections returns the top-level multipart sections of the given message
+func sections(t *testing.T, message []byte, media string) (map[string]string, []string) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Header.Get("Subject") != "hello world" {
+		t.Fatal("Unexpected subject:", parsed.Header.Get("Subject"))
+	}
+
+	result, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != media {
+		t.Fatal("Unexpected content type:", result)
+	}
+
+	delimiter := CRLF + "--" + params["boundary"]
+	content := string(message)
+	content = content[strings.Index(content, delimiter[len(CRLF):])+len(delimiter)-len(CRLF):]
+	content = content[:strings.Index(content, delimiter+"--")]
+
+	return params, strings.Split(strings.TrimPrefix(content, CRLF), delimiter+CRLF)
+}
+
+// TestPGPSign test if the detached signature could be verified with the signing key
+func TestPGPSign(t *testing.T) {
+	signer := PGPSigner{Entity: pgpEntity(t)}
+
+	signed, err := signer.Sign(pgpEnvelope(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, parts := sections(t, signed, "multipart/signed")
+	if params["protocol"] != "application/pgp-signature" || params["micalg"] != "pgp-sha256" {
+		t.Fatal("Unexpected parameters:", params)
+	}
+
+	if len(parts) != 2 {
+		t.Fatal("Unexpected amount of parts:", len(parts))
+	}
+
+	content := parts[0]
+	if !strings.HasPrefix(content, "Content-Type: multipart/alternative") {
+		t.Fatal("Unexpected signed content:", content)
+	}
+
+	signature := parts[1]
+	if !strings.HasPrefix(signature, "Content-Description: OpenPGP digital signature"+CRLF) {
+		t.Fatal("Unexpected signature part:", signature)
+	}
+
+	signature = signature[strings.Index(signature, CRLF+CRLF)+len(CRLF+CRLF):]
+	keyring := openpgp.EntityList{signer.Entity}
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(content), strings.NewReader(signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(content+" "), strings.NewReader(signature))
+	if err == nil {
+		t.Fatal("Expected modified content to be rejected")
+	}
+}
+
+// TestPGPEncrypt test if the encrypted entity could be decrypted by the recipient
+func TestPGPEncrypt(t *testing.T) {
+	recipient := pgpEntity(t)
+	signer := pgpEntity(t)
+	encryptor := PGPEncryptor{Recipients: []*openpgp.Entity{recipient}, Signer: signer}
+
+	message := pgpEnvelope(t)
+	encrypted, err := encryptor.Encrypt(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := structure(t, string(encrypted))
+	if result != "multipart/encrypted(application/pgp-encrypted,application/octet-stream)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	params, parts := sections(t, encrypted, "multipart/encrypted")
+	if params["protocol"] != "application/pgp-encrypted" {
+		t.Fatal("Unexpected parameters:", params)
+	}
+
+	if !strings.HasSuffix(parts[0], CRLF+CRLF+"Version: 1"+CRLF) {
+		t.Fatal("Unexpected control part:", parts[0])
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(encrypted[bytes.Index(encrypted, []byte(CRLF+CRLF)):]), params["boundary"])
+	reader.NextPart()
+
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := armor.Decode(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{recipient, signer}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := io.ReadAll(details.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.SignatureError != nil || details.SignedBy == nil {
+		t.Fatal("Unexpected signature:", details.SignatureError)
+	}
+
+	_, entity, err := splitEntity(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, entity) {
+		t.Fatalf("Unexpected decrypted content: %q", decrypted)
+	}
+
+	_, err = (&PGPEncryptor{}).Encrypt(message)
+	if err != ErrNoPGPRecipients {
+		t.Fatal("Unexpected error:", err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package postboxtest provides utilities to assert the structure of rendered
+// messages. Rendered output is parsed into a tree of entities containing the
+// entity headers, decoded content and nested parts.
+//
+//	message := postboxtest.Record(t, &envelope)
+//	message.Part(0).Header("Content-Type")
+package postboxtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// ErrEmptyMessage is returned when no message has been written to the writer
+var ErrEmptyMessage = errors.New("no message has been written")
+
+// Entity represents a parsed message or message part
+type Entity struct {
+	// Headers contains the raw entity headers
+	Headers textproto.MIMEHeader
+	// MediaType contains the lower-case media type of the entity (ex:
+	// text/plain), text/plain is assumed when no Content-Type is set
+	MediaType string
+	// Params contains the Content-Type parameters (ex: charset)
+	Params map[string]string
+	// Body contains the decoded content of non multipart entities
+	Body []byte
+	// Parts contains the nested entities of multipart entities
+	Parts []*Entity
+}
+
+// Header returns the first value of the given header key. An empty string is
+// returned when the header is not set or the entity is nil.
+func (e *Entity) Header(key string) string {
+	if e == nil {
+		return ""
+	}
+
+	return e.Headers.Get(key)
+}
+
+// Part returns the nested part at the given position. Multiple positions
+// could be given to walk down the tree (ex: Part(0, 1) returns the second
+// part of the first part). Nil is returned when no part exists at the given
+// position.
+func (e *Entity) Part(positions ...int) *Entity {
+	result := e
+	for _, position := range positions {
+		if result == nil || position < 0 || position >= len(result.Parts) {
+			return nil
+		}
+
+		result = result.Parts[position]
+	}
+
+	return result
+}
+
+// Text returns the decoded body of the entity as string
+func (e *Entity) Text() string {
+	if e == nil {
+		return ""
+	}
+
+	return string(e.Body)
+}
+
+// Multipart returns whether the entity is a multipart entity
+func (e *Entity) Multipart() bool {
+	return e != nil && strings.HasPrefix(e.MediaType, "multipart/")
+}
+
+// Find returns the first entity, in depth-first order, with the given media
+// type. Nil is returned when no entity has been found.
+func (e *Entity) Find(media string) *Entity {
+	if e == nil {
+		return nil
+	}
+
+	if e.MediaType == strings.ToLower(media) {
+		return e
+	}
+
+	for _, part := range e.Parts {
+		result := part.Find(media)
+		if result != nil {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// Structure returns the media type tree of the entity
+// (ex: multipart/mixed(multipart/alternative(text/plain,text/html),image/png))
+func (e *Entity) Structure() string {
+	if e == nil {
+		return ""
+	}
+
+	if !e.Multipart() {
+		return e.MediaType
+	}
+
+	children := make([]string, len(e.Parts))
+	for index, part := range e.Parts {
+		children[index] = part.Structure()
+	}
+
+	return e.MediaType + "(" + strings.Join(children, ",") + ")"
+}
+
+// Parse reads a rendered message from the given reader and returns the
+// parsed entity tree
+func Parse(reader io.Reader) (*Entity, error) {
+	message, err := mail.ReadMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(textproto.MIMEHeader(message.Header), message.Body)
+}
+
+// parse parses the given entity and all nested parts
+func parse(header textproto.MIMEHeader, body io.Reader) (*Entity, error) {
+	entity := &Entity{
+		Headers:   header,
+		MediaType: "text/plain",
+		Params:    map[string]string{},
+	}
+
+	if header.Get("Content-Type") != "" {
+		media, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+
+		entity.MediaType = media
+		entity.Params = params
+	}
+
+	if !entity.Multipart() {
+		content, err := io.ReadAll(decode(body, header.Get("Content-Transfer-Encoding")))
+		if err != nil {
+			return nil, err
+		}
+
+		entity.Body = content
+		return entity, nil
+	}
+
+	reader := multipart.NewReader(body, entity.Params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := parse(part.Header, part)
+		if err != nil {
+			return nil, fmt.Errorf("parsing part %d of %s: %w", len(entity.Parts), entity.MediaType, err)
+		}
+
+		entity.Parts = append(entity.Parts, child)
+	}
+
+	return entity, nil
+}
+
+// decode returns a reader decoding the given content transfer encoding
+func decode(reader io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(reader)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, reader)
+	default:
+		return reader
+	}
+}
+
+// Writer is a fake io.Writer recording a rendered message (ex: as
+// destination of Envelope.Write)
+type Writer struct {
+	buffer bytes.Buffer
+}
+
+// Write records the given bytes
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+// Bytes returns the recorded message
+func (w *Writer) Bytes() []byte {
+	return w.buffer.Bytes()
+}
+
+// Message parses the recorded message. ErrEmptyMessage is returned when
+// nothing has been written.
+func (w *Writer) Message() (*Entity, error) {
+	if w.buffer.Len() == 0 {
+		return nil, ErrEmptyMessage
+	}
+
+	return Parse(bytes.NewReader(w.buffer.Bytes()))
+}
+
+// MessageWriter represents a message which could be written to an io.Writer
+// (ex: *postbox.Envelope)
+type MessageWriter interface {
+	Write(io.Writer) error
+}
+
+// Record writes the given message and returns the parsed entity tree. The
+// test is marked as failed and stopped when the message could not be
+// written or parsed.
+func Record(t testing.TB, message MessageWriter) *Entity {
+	t.Helper()
+
+	writer := &Writer{}
+	err := message.Write(writer)
+	if err != nil {
+		t.Fatal("Unexpected error while writing message:", err)
+	}
+
+	result, err := writer.Message()
+	if err != nil {
+		t.Fatal("Unexpected error while parsing message:", err)
+	}
+
+	return result
+}
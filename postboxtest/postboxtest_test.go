@@ -0,0 +1,128 @@
+package postboxtest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jeroenrinzema/postbox"
+	"github.com/jeroenrinzema/postbox/postboxtest"
+)
+
+// TestRecord test if a rendered envelope is parsed into a structured tree
+func TestRecord(t *testing.T) {
+	envelope := postbox.Envelope{
+		From:        "john@example.com",
+		To:          []string{"boss@example.com"},
+		Subject:     "hello world",
+		Parts:       postbox.NewAlternative("hello world", "<p>hello world</p>"),
+		Attachments: []*postbox.File{postbox.AttachBytes("report.csv", "text/csv", []byte("id,name\n1,john\n"))},
+	}
+
+	message := postboxtest.Record(t, &envelope)
+
+	if message.Header("Subject") != "hello world" {
+		t.Fatal("Unexpected subject:", message.Header("Subject"))
+	}
+
+	if message.Structure() != "multipart/mixed(multipart/alternative(text/plain,text/html),text/csv)" {
+		t.Fatal("Unexpected MIME tree:", message.Structure())
+	}
+
+	if !strings.HasPrefix(message.Part(0).Header("Content-Type"), "multipart/alternative") {
+		t.Fatal("Unexpected content type:", message.Part(0).Header("Content-Type"))
+	}
+
+	if message.Part(0, 0).Text() != "hello world" {
+		t.Fatal("Unexpected plain text:", message.Part(0, 0).Text())
+	}
+
+	if message.Part(0, 1).Params["charset"] != "utf-8" {
+		t.Fatal("Unexpected charset:", message.Part(0, 1).Params)
+	}
+
+	attachment := message.Find("text/csv")
+	if attachment != message.Part(1) {
+		t.Fatal("Unexpected attachment:", attachment)
+	}
+
+	if attachment.Header("Content-Transfer-Encoding") != "base64" || attachment.Text() != "id,name\n1,john\n" {
+		t.Fatal("Unexpected attachment content:", attachment.Text())
+	}
+}
+
+// TestPartMissing test if missing parts could be safely asserted
+func TestPartMissing(t *testing.T) {
+	envelope := postbox.Envelope{
+		From:    "john@example.com",
+		To:      []string{"boss@example.com"},
+		Subject: "hello world",
+		Parts:   postbox.NewAlternative("hello world", "<p>hello world</p>"),
+	}
+
+	message := postboxtest.Record(t, &envelope)
+
+	if message.Part(5) != nil || message.Part(0, 0, 0) != nil || message.Part(-1) != nil {
+		t.Fatal("Unexpected part")
+	}
+
+	if message.Part(5).Header("Content-Type") != "" || message.Part(5).Text() != "" || message.Part(5).Structure() != "" {
+		t.Fatal("Unexpected value of missing part")
+	}
+
+	if message.Find("image/png") != nil {
+		t.Fatal("Unexpected entity")
+	}
+}
+
+// TestWriterMessage test if the fake writer records and parses written messages
+func TestWriterMessage(t *testing.T) {
+	writer := &postboxtest.Writer{}
+
+	_, err := writer.Message()
+	if !errors.Is(err, postboxtest.ErrEmptyMessage) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	message := postbox.NewMessageWriter(writer)
+	message.Header(postbox.Headers{"Subject": {"hello world"}})
+
+	part, err := message.AddPart("text/plain", postbox.QuotedPrintable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	part.Write([]byte("café"))
+
+	err = message.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := writer.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Structure() != "multipart/mixed(text/plain)" {
+		t.Fatal("Unexpected MIME tree:", result.Structure())
+	}
+
+	if result.Part(0).Header("Content-Transfer-Encoding") != "quoted-printable" || result.Part(0).Text() != "café" {
+		t.Fatal("Unexpected part:", result.Part(0).Text())
+	}
+
+	if !result.Multipart() || result.Part(0).Multipart() {
+		t.Fatal("Unexpected multipart state")
+	}
+}
+
+// TestParseInvalid test if malformed messages return an error
+func TestParseInvalid(t *testing.T) {
+	message := "Content-Type: multipart/mixed; boundary=boundary\r\n\r\n--boundary\r\nContent-Type: text/plain; charset\r\n\r\nhello\r\n--boundary--\r\n"
+
+	_, err := postboxtest.Parse(strings.NewReader(message))
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+}
@@ -0,0 +1,59 @@
+package postbox
+
+import (
+	"fmt"
+	"io"
+)
+
+// RawPart represents a pre-built MIME entity containing both the entity
+// headers and body (ex: a part produced by another system). The content is
+// copied as is and could be used as escape hatch for entities which could
+// not be expressed using a Part or File. The content should use CRLF line
+// endings, include a blank line between the headers and body and should not
+// contain the boundaries of the message.
+type RawPart struct {
+	Reader     io.Reader
+	ReaderFunc func() io.Reader // constructs a new reader on every write, preferred over Reader
+}
+
+// Write copies the raw entity to the given io.Writer. The charset is ignored
+// since the entity headers are written as is.
+func (r *RawPart) Write(writer io.Writer, charset string) error {
+	reader := r.reader()
+	if reader == nil {
+		return ErrNoReader
+	}
+
+	_, err := io.Copy(writer, reader)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(crlf)
+	return err
+}
+
+// reader returns the reader of the raw entity. The ReaderFunc is preferred
+// over the Reader when set.
+func (r *RawPart) reader() io.Reader {
+	if r.ReaderFunc != nil {
+		return r.ReaderFunc()
+	}
+
+	return r.Reader
+}
+
+// raw constructs a new entity writing the given raw part. Errors are wrapped
+// with the (1-based) position of the raw part.
+func (e *Envelope) raw(position int, part *RawPart) *entity {
+	return &entity{
+		write: func(writer io.Writer) error {
+			err := part.Write(writer, e.Charset)
+			if err != nil {
+				return fmt.Errorf("writing raw part %d: %w", position, err)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package postbox
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestWritingRawPart test if the raw part is written as is between the boundary markers
+func TestWritingRawPart(t *testing.T) {
+	raw := "Content-Type: application/x-custom; charset=utf-8" + CRLF +
+		"Content-Transfer-Encoding: 7bit" + CRLF +
+		"X-Custom: untouched=value;;" + CRLF +
+		CRLF +
+		"raw  content =3D kept" + CRLF +
+		"second line"
+
+	envelope := Envelope{
+		From:         "john@example.com",
+		To:           []string{"boss@example.com"},
+		BoundaryFunc: func() string { return "boundary" },
+		Parts:        text("hello world"),
+		RawParts: []*RawPart{
+			{Reader: strings.NewReader(raw)},
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output, "--boundary"+CRLF+raw+CRLF+"--boundary--") {
+		t.Fatal("Raw part not written as is:", output)
+	}
+
+	result := structure(t, output)
+	if result != "multipart/mixed(text/plain,application/x-custom)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+}
@@ -0,0 +1,45 @@
+package postbox
+
+import (
+	"strings"
+)
+
+// NewReply constructs a new envelope replying to the given original envelope.
+// The subject is prefixed with "Re: " unless already present, the threading
+// headers (In-Reply-To, References) are set based on the original Message-ID
+// and the reply is addressed to the original Reply-To or From address. The
+// first original To recipient is used as From address.
+func NewReply(original *Envelope) *Envelope {
+	reply := &Envelope{
+		Subject: replySubject(original.Subject),
+		Charset: original.Charset,
+		To:      []string{original.author()},
+	}
+
+	if len(original.ReplyTo) > 0 {
+		reply.To = cloneStrings(original.ReplyTo)
+	}
+
+	if len(original.To) > 0 {
+		reply.From = original.To[0]
+	}
+
+	reply.References = append(reply.References, original.References...)
+
+	if original.MessageID != "" {
+		reply.InReplyTo = original.MessageID
+		reply.References = append(reply.References, original.MessageID)
+	}
+
+	return reply
+}
+
+// replySubject prefixes the given subject with "Re: " unless the subject
+// already starts with a (case insensitive) reply prefix
+func replySubject(subject string) string {
+	if len(subject) >= 3 && strings.EqualFold(subject[:3], "re:") {
+		return subject
+	}
+
+	return "Re: " + subject
+}
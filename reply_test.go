@@ -0,0 +1,61 @@
+package postbox
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReplySubject test if the reply prefix is not doubled
+func TestReplySubject(t *testing.T) {
+	tests := map[string]string{
+		"hello world":     "Re: hello world",
+		"Re: hello world": "Re: hello world",
+		"RE: hello world": "RE: hello world",
+		"re:hello world":  "re:hello world",
+		"Regarding you":   "Re: Regarding you",
+	}
+
+	for subject, expected := range tests {
+		reply := NewReply(&Envelope{Subject: subject})
+		if reply.Subject != expected {
+			t.Fatal("Unexpected subject:", reply.Subject, "expected:", expected)
+		}
+	}
+}
+
+// TestReplyThreading test if the references are accumulated across replies
+func TestReplyThreading(t *testing.T) {
+	original := &Envelope{
+		MessageID: "<1@example.com>",
+		From:      "john@example.com",
+		To:        []string{"boss@example.com", "dan@example.com"},
+		Subject:   "hello world",
+	}
+
+	reply := NewReply(original)
+	reply.MessageID = "<2@example.com>"
+
+	if reply.InReplyTo != "<1@example.com>" || strings.Join(reply.References, " ") != "<1@example.com>" {
+		t.Fatal("Unexpected threading:", reply.InReplyTo, reply.References)
+	}
+
+	if reply.From != "boss@example.com" || strings.Join(reply.To, ",") != "john@example.com" {
+		t.Fatal("Unexpected addresses:", reply.From, reply.To)
+	}
+
+	second := NewReply(reply)
+	if second.InReplyTo != "<2@example.com>" || strings.Join(second.References, " ") != "<1@example.com> <2@example.com>" {
+		t.Fatal("Unexpected threading:", second.InReplyTo, second.References)
+	}
+
+	if second.Subject != "Re: hello world" {
+		t.Fatal("Unexpected subject:", second.Subject)
+	}
+
+	original.ReplyTo = []string{"support@example.com"}
+
+	reply = NewReply(original)
+	if strings.Join(reply.To, ",") != "support@example.com" {
+		t.Fatal("Reply-To not respected:", reply.To)
+	}
+}
@@ -0,0 +1,82 @@
+package postbox
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+const (
+	// DeliveryStatusReport represents the report type of delivery status
+	// notifications (DSN) as defined in RFC 3464.
+	DeliveryStatusReport = "delivery-status"
+	// DispositionNotificationReport represents the report type of message
+	// disposition notifications (MDN, read receipts) as defined in RFC 8098.
+	DispositionNotificationReport = "disposition-notification"
+)
+
+// DeliveryStatus represents the per-message fields of a delivery status
+// notification as defined in RFC 3464 section 2.2.
+type DeliveryStatus struct {
+	ReportingMTA string    // (ex: dns; mail.example.com)
+	ArrivalDate  time.Time // omitted when zero
+	Recipients   []RecipientStatus
+}
+
+// RecipientStatus represents the per-recipient fields of a delivery status
+// notification as defined in RFC 3464 section 2.3. Addresses without an
+// address type (ex: john@example.com) are written as rfc822 addresses.
+type RecipientStatus struct {
+	OriginalRecipient string // optional
+	FinalRecipient    string
+	Action            string // failed, delayed, delivered, relayed or expanded
+	Status            string // (ex: 5.1.1)
+	RemoteMTA         string // optional (ex: dns; mx.example.com)
+	DiagnosticCode    string // optional (ex: smtp; 550 5.1.1 user unknown)
+}
+
+// NewDeliveryStatusPart constructs a message/delivery-status part containing
+// the given status. The part should be written as second part of a
+// multipart/report envelope (see Envelope.ReportType), preceded by a human
+// readable explanation.
+func NewDeliveryStatusPart(status DeliveryStatus) *Part {
+	buffer := bytes.NewBuffer(nil)
+	field := func(name string, value string) {
+		if value == "" {
+			return
+		}
+
+		buffer.WriteString(name + ": " + value + CRLF)
+	}
+
+	field("Reporting-MTA", status.ReportingMTA)
+	if !status.ArrivalDate.IsZero() {
+		field("Arrival-Date", status.ArrivalDate.Format(RFC5322Date))
+	}
+
+	for _, recipient := range status.Recipients {
+		buffer.WriteString(CRLF)
+		field("Original-Recipient", addressType(recipient.OriginalRecipient))
+		field("Final-Recipient", addressType(recipient.FinalRecipient))
+		field("Action", recipient.Action)
+		field("Status", recipient.Status)
+		field("Remote-MTA", recipient.RemoteMTA)
+		field("Diagnostic-Code", recipient.DiagnosticCode)
+	}
+
+	return &Part{
+		ContentType: "message/delivery-status",
+		Encoding:    SevenBit,
+		ReaderFunc:  content(buffer.String()),
+	}
+}
+
+// addressType prefixes the given address with the rfc822 address type when
+// no address type is given
+func addressType(address string) string {
+	if address == "" || strings.Contains(address, ";") {
+		return address
+	}
+
+	return "rfc822; " + address
+}
@@ -0,0 +1,96 @@
+package postbox
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWritingDeliveryStatusReport test if a delivery status notification is written as multipart/report
+func TestWritingDeliveryStatusReport(t *testing.T) {
+	status := DeliveryStatus{
+		ReportingMTA: "dns; mail.example.com",
+		ArrivalDate:  time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC),
+		Recipients: []RecipientStatus{
+			{
+				FinalRecipient: "boss@example.com",
+				Action:         "failed",
+				Status:         "5.1.1",
+				DiagnosticCode: "smtp; 550 5.1.1 user unknown",
+			},
+		},
+	}
+
+	envelope := Envelope{
+		From:       "mailer-daemon@example.com",
+		To:         []string{"john@example.com"},
+		Subject:    "Delivery Status Notification (Failure)",
+		ReportType: DeliveryStatusReport,
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("Your message could not be delivered to boss@example.com"),
+			},
+			NewDeliveryStatusPart(status),
+			{
+				ContentType: "text/rfc822-headers",
+				Encoding:    SevenBit,
+				Reader:      strings.NewReader("From: john@example.com" + CRLF + "To: boss@example.com" + CRLF),
+			},
+		},
+	}
+
+	output, err := envelope.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := structure(t, output)
+	if result != "multipart/report(text/plain,message/delivery-status,text/rfc822-headers)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params["report-type"] != DeliveryStatusReport {
+		t.Fatal("Unexpected report type:", params["report-type"])
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	reader.NextPart()
+
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Reporting-MTA: dns; mail.example.com" + CRLF +
+		"Arrival-Date: Tue, 10 Nov 2009 23:00:00 +0000" + CRLF +
+		CRLF +
+		"Final-Recipient: rfc822; boss@example.com" + CRLF +
+		"Action: failed" + CRLF +
+		"Status: 5.1.1" + CRLF +
+		"Diagnostic-Code: smtp; 550 5.1.1 user unknown" + CRLF
+
+	if string(body) != expected {
+		t.Fatalf("Unexpected delivery status: %q", body)
+	}
+}
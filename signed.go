@@ -0,0 +1,94 @@
+package postbox
+
+import (
+	"bytes"
+	"strings"
+)
+
+// sign wraps the MIME entity of the given rendered message inside a
+// multipart/signed entity as defined in RFC 1847 section 2.1 using the given
+// Content-Type parameters (ex: protocol and micalg). The given function
+// signs the canonical entity and returns the headers and encoded body of the
+// signature part.
+func sign(message []byte, params []string, fn func(content []byte) (Headers, []byte, error)) ([]byte, error) {
+	headers, content, err := splitEntity(message)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, signature, err := fn(content)
+	if err != nil {
+		return nil, err
+	}
+
+	identifier, err := GenerateBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	result := bytes.NewBuffer(nil)
+	result.Write(headers)
+
+	boundary := StartBoundary(result, identifier, "multipart/signed", params...)
+	boundary.Mark()
+
+	result.Write(content)
+	result.WriteString(CRLF)
+
+	boundary.Mark()
+
+	err = attachment.Write(result)
+	if err != nil {
+		return nil, err
+	}
+
+	result.WriteString(CRLF)
+	result.Write(signature)
+	result.WriteString(CRLF)
+
+	boundary.End()
+
+	return result.Bytes(), nil
+}
+
+// splitEntity splits the given rendered message into the message headers and
+// the MIME entity containing the Content headers and body. Bare LF line
+// endings inside the entity are converted into CRLF.
+func splitEntity(message []byte) ([]byte, []byte, error) {
+	fields, body, err := splitMessage(message)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := bytes.NewBuffer(nil)
+	entity := bytes.NewBuffer(nil)
+
+	for _, field := range fields {
+		if strings.HasPrefix(strings.ToLower(fieldName(field)), "content-") {
+			entity.WriteString(field)
+			continue
+		}
+
+		headers.WriteString(field)
+	}
+
+	entity.WriteString(CRLF)
+	entity.Write(body)
+
+	return headers.Bytes(), canonicalLines(entity.Bytes()), nil
+}
+
+// canonicalLines converts all bare LF line endings into CRLF
+func canonicalLines(content []byte) []byte {
+	result := make([]byte, 0, len(content))
+
+	for index, char := range content {
+		if char == '\n' && (index == 0 || content[index-1] != '\r') {
+			result = append(result, '\r')
+		}
+
+		result = append(result, char)
+	}
+
+	return result
+}
@@ -0,0 +1,127 @@
+package postbox
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrUnknownSize is returned when the size of a message could not be
+// estimated without consuming the content of a part
+var ErrUnknownSize = errors.New("size could not be estimated")
+
+// EstimatedSize returns the size in bytes of the rendered message (ex: to
+// check the SMTP SIZE limit before sending). The content of base64 encoded
+// files with a Size is not read, the size of the encoded content is
+// calculated instead. All other files are rendered using their CopyFunc and
+// should therefore be readable multiple times, ErrUnknownSize is returned for
+// files constructed through AttachReader. Parts and raw parts without a
+// ReaderFunc require a Reader implementing io.Seeker which is rewound once
+// the size has been estimated, ErrUnknownSize is returned otherwise.
+func (e *Envelope) EstimatedSize() (int64, error) {
+	readers := []io.Reader{}
+	for _, part := range e.Parts {
+		if part.ReaderFunc == nil {
+			readers = append(readers, part.Reader)
+		}
+	}
+
+	for _, raw := range e.RawParts {
+		if raw.ReaderFunc == nil {
+			readers = append(readers, raw.Reader)
+		}
+	}
+
+	seekers := make([]io.Seeker, len(readers))
+	offsets := make([]int64, len(readers))
+
+	for index, reader := range readers {
+		seeker, ok := reader.(io.Seeker)
+		if !ok {
+			return 0, ErrUnknownSize
+		}
+
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+
+		seekers[index] = seeker
+		offsets[index] = offset
+	}
+
+	defer func() {
+		for index, seeker := range seekers {
+			seeker.Seek(offsets[index], io.SeekStart)
+		}
+	}()
+
+	total := int64(0)
+	estimate := *e
+	embedded, err := sized(e.Embedded, e.LineLength, &total)
+	if err != nil {
+		return 0, err
+	}
+
+	attachments, err := sized(e.Attachments, e.LineLength, &total)
+	if err != nil {
+		return 0, err
+	}
+
+	estimate.Embedded = embedded
+	estimate.Attachments = attachments
+
+	written, err := estimate.WriteTo(io.Discard)
+	if err != nil {
+		return 0, err
+	}
+
+	return total + written, nil
+}
+
+// sized returns copies of the given files where the content of files with a
+// known size is omitted. The encoded size of the omitted content is added to
+// the given total. The given line length is used for files without a line
+// length. ErrUnknownSize is returned when the content of a file which could
+// only be read once (see AttachReader) has to be rendered.
+func sized(files []*File, length int, total *int64) ([]*File, error) {
+	result := make([]*File, len(files))
+	for index, file := range files {
+		result[index] = file
+		// The size of compressed content is only known once compressed and
+		// only the size of base64 encoded content could be calculated
+		if file.Size <= 0 || file.Compress || file.encoding() != Base64 {
+			if file.once {
+				return nil, ErrUnknownSize
+			}
+
+			continue
+		}
+
+		omitted := *file
+		omitted.CopyFunc = func(io.Writer) error {
+			return nil
+		}
+
+		result[index] = &omitted
+		width := length
+		if file.LineLength > 0 {
+			width = file.LineLength
+		}
+
+		*total += base64Size(file.Size, int64(lineLength(width)))
+	}
+
+	return result, nil
+}
+
+// base64Size returns the size of the base64 encoded content of the given size
+// including the CRLF characters inserted after every line of the given length
+func base64Size(size int64, length int64) int64 {
+	encoded := (size + 2) / 3 * 4
+	if encoded == 0 {
+		return 0
+	}
+
+	lines := (encoded + length - 1) / length
+	return encoded + (lines-1)*int64(len(CRLF))
+}
@@ -0,0 +1,139 @@
+package postbox
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestEstimatedSize test if the estimated size matches the rendered message size
+func TestEstimatedSize(t *testing.T) {
+	for _, size := range []int64{0, 1, 56, 57, 58, 1000, 1 << 20} {
+		content := bytes.Repeat([]byte{0xff}, int(size))
+		read := false
+
+		envelope := Envelope{
+			From:         "john@example.com",
+			To:           []string{"boss@example.com"},
+			Subject:      "hello world",
+			BoundaryFunc: func() string { return "boundary" },
+			Parts:        NewAlternative("Grüße", "<p>Grüße</p>"),
+			Attachments: []*File{
+				{
+					Name: "report.pdf",
+					Size: size,
+					CopyFunc: func(w io.Writer) error {
+						read = true
+						_, err := w.Write(content)
+						return err
+					},
+				},
+			},
+		}
+
+		estimate, err := envelope.EstimatedSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read && size > 0 {
+			t.Fatal("Attachment content has been read while estimating")
+		}
+
+		output, err := capture(&envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if estimate != int64(len(output)) {
+			t.Fatal("Unexpected estimated size:", size, estimate, len(output))
+		}
+	}
+
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"boss@example.com"},
+		Parts: []*Part{
+			{ContentType: "text/plain", Reader: io.MultiReader(strings.NewReader("hello world"))},
+		},
+	}
+
+	_, err := envelope.EstimatedSize()
+	if err != ErrUnknownSize {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestEstimatedSizeLineLength test if the line length of a file is only used for the file itself
+func TestEstimatedSizeLineLength(t *testing.T) {
+	content := bytes.Repeat([]byte{0xff}, 3000)
+	file := func(length int) *File {
+		return &File{
+			Name:       "report.pdf",
+			Size:       int64(len(content)),
+			LineLength: length,
+			CopyFunc: func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			},
+		}
+	}
+
+	envelope := Envelope{
+		From:         "john@example.com",
+		To:           []string{"boss@example.com"},
+		BoundaryFunc: func() string { return "boundary" },
+		Parts:        NewAlternative("hello world", "<p>hello world</p>"),
+		LineLength:   64,
+		Attachments:  []*File{file(10), file(0), file(0)},
+	}
+
+	estimate, err := envelope.EstimatedSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := capture(&envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate != int64(len(output)) {
+		t.Fatal("Unexpected estimated size:", estimate, len(output))
+	}
+}
+
+// TestEstimatedSizeReader test if files which could only be read once are not consumed while estimating
+func TestEstimatedSizeReader(t *testing.T) {
+	for _, size := range []int64{0, 11} {
+		report := AttachReader("report.csv", "text/csv", strings.NewReader("hello world"))
+		report.Size = size
+
+		envelope := Envelope{
+			From:        "john@example.com",
+			To:          []string{"boss@example.com"},
+			Parts:       text("hello world"),
+			Attachments: []*File{report},
+		}
+
+		_, err := envelope.EstimatedSize()
+		if size == 0 && err != ErrUnknownSize {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		if size > 0 && err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := capture(&envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// base64 encoded "hello world"
+		if !strings.Contains(output, "aGVsbG8gd29ybGQ=") {
+			t.Fatal("Attachment content consumed while estimating:", size, output)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package postbox
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// contentInfo represents a PKCS #7 ContentInfo as defined in RFC 5652
+// section 3. The content is omitted for detached signatures.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// signedData represents a PKCS #7 SignedData as defined in RFC 5652 section 5.1
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// signerInfo represents a PKCS #7 SignerInfo as defined in RFC 5652 section 5.3
+type signerInfo struct {
+	Version            int
+	IssuerAndSerial    issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// attribute represents a signed PKCS #7 attribute
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// SMIMESigner signs rendered messages with a detached S/MIME signature as
+// defined in RFC 8551. The message content is wrapped inside a
+// multipart/signed entity (RFC 1847) containing a PKCS #7 signature. RSA and
+// ECDSA keys are supported.
+//
+// Parts should not be written using the 8bit or binary encoding since any
+// modification of the signed content during transport breaks the signature.
+// Messages should be signed with S/MIME before being signed with DKIM.
+type SMIMESigner struct {
+	Certificate *x509.Certificate
+	Key         crypto.Signer // *rsa.PrivateKey or *ecdsa.PrivateKey
+
+	// Intermediates are included inside the signature allowing receivers to
+	// verify the certificate chain
+	Intermediates []*x509.Certificate
+}
+
+// Sign signs the given rendered message (ex: Envelope.Bytes) and returns the
+// multipart/signed message. The Content headers of the message are moved into
+// the signed entity, all other headers are kept. Bare LF line endings inside
+// the signed entity are converted into CRLF before signing.
+func (s *SMIMESigner) Sign(message []byte) ([]byte, error) {
+	algorithm, err := s.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	params := []string{`protocol="application/pkcs7-signature"`, "micalg=sha-256"}
+	return sign(message, params, func(content []byte) (Headers, []byte, error) {
+		signature, err := s.signature(content, algorithm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encoded := bytes.NewBuffer(nil)
+		encoder := newBase64Writer(encoded, MaxLineLength)
+		encoder.Write(signature)
+		encoder.Close()
+
+		headers := Headers{
+			"Content-Type":              {"application/pkcs7-signature", `name="smime.p7s"`},
+			"Content-Transfer-Encoding": {string(Base64)},
+			"Content-Disposition":       {string(Attachment), `filename="smime.p7s"`},
+		}
+
+		return headers, encoded.Bytes(), nil
+	})
+}
+
+// algorithm returns the signature algorithm of the configured key
+func (s *SMIMESigner) algorithm() (pkix.AlgorithmIdentifier, error) {
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PrivateKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSASHA256}, nil
+	}
+
+	return pkix.AlgorithmIdentifier{}, ErrUnsupportedKey
+}
+
+// signature returns the DER encoded detached PKCS #7 signature of the given
+// content
+func (s *SMIMESigner) signature(content []byte, algorithm pkix.AlgorithmIdentifier) ([]byte, error) {
+	digest := sha256.Sum256(content)
+
+	attributes, err := signedAttributes(
+		attributeValue{oidContentType, oidData},
+		attributeValue{oidMessageDigest, digest[:]},
+		attributeValue{oidSigningTime, time.Now().UTC()},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is calculated over the DER encoded SET OF attributes
+	// instead of the implicitly tagged value included inside the signer info
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := sha256.Sum256(set)
+	signature, err := s.Key.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := []byte{}
+	for _, certificate := range append([]*x509.Certificate{s.Certificate}, s.Intermediates...) {
+		certificates = append(certificates, certificate.Raw...)
+	}
+
+	sha := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	data, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos: []signerInfo{
+			{
+				Version: 1,
+				IssuerAndSerial: issuerAndSerial{
+					Issuer: asn1.RawValue{FullBytes: s.Certificate.RawIssuer},
+					Serial: s.Certificate.SerialNumber,
+				},
+				DigestAlgorithm:    sha,
+				SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributes},
+				SignatureAlgorithm: algorithm,
+				Signature:          signature,
+			},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data},
+	})
+}
+
+// attributeValue represents a single valued attribute
+type attributeValue struct {
+	oid   asn1.ObjectIdentifier
+	value interface{}
+}
+
+// signedAttributes returns the DER encoded attributes sorted as required for
+// a DER encoded SET OF
+func signedAttributes(values ...attributeValue) ([]byte, error) {
+	encoded := make([][]byte, 0, len(values))
+
+	for _, value := range values {
+		raw, err := asn1.Marshal(value.value)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := asn1.Marshal(attribute{
+			Type:   value.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: raw},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = append(encoded, result)
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return bytes.Join(encoded, nil), nil
+}
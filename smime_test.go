@@ -0,0 +1,221 @@
+package postbox
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// certificate generates a self-signed S/MIME certificate for the given key
+func certificate(t *testing.T, key interface{}, public interface{}) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: "John Doe"},
+		EmailAddresses: []string{"john@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, public, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// TestSMIMESign test if the detached signature could be verified with the signing certificate
+func TestSMIMESign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := []*SMIMESigner{
+		{Certificate: certificate(t, rsaKey, rsaKey.Public()), Key: rsaKey},
+		{Certificate: certificate(t, ecKey, ecKey.Public()), Key: ecKey},
+	}
+
+	for _, signer := range signers {
+		envelope := Envelope{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com"},
+			Subject: "hello world",
+			Parts:   NewAlternative("hello world", "<p>hello world</p>"),
+		}
+
+		message, err := envelope.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := signer.Sign(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, signature := splitSigned(t, signed)
+		if !strings.HasPrefix(string(content), "Content-Type: multipart/alternative") {
+			t.Fatal("Unexpected signed content:", string(content))
+		}
+
+		verifySMIME(t, content, signature, signer.Certificate)
+	}
+}
+
+// splitSigned returns the signed content and the decoded signature of the given
+// multipart/signed message
+func splitSigned(t *testing.T, message []byte) ([]byte, []byte) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if media != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+		t.Fatal("Unexpected content type:", media, params)
+	}
+
+	if parsed.Header.Get("Subject") != "hello world" {
+		t.Fatal("Unexpected subject:", parsed.Header.Get("Subject"))
+	}
+
+	delimiter := "--" + params["boundary"] + CRLF
+	sections := strings.Split(string(message), CRLF+delimiter)
+	if len(sections) != 3 {
+		t.Fatal("Unexpected amount of sections:", len(sections))
+	}
+
+	content := sections[1]
+	attachment := sections[2]
+	encoded := attachment[strings.Index(attachment, CRLF+CRLF)+len(CRLF+CRLF):]
+	encoded = encoded[:strings.Index(encoded, CRLF+"--"+params["boundary"]+"--")]
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(content), signature
+}
+
+// verifySMIME verifies the given detached PKCS #7 signature of the given content
+func verifySMIME(t *testing.T, content []byte, signature []byte, certificate *x509.Certificate) {
+	info := contentInfo{}
+	_, err := asn1.Unmarshal(signature, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.ContentType.Equal(oidSignedData) {
+		t.Fatal("Unexpected content type:", info.ContentType)
+	}
+
+	data := signedData{}
+	_, err = asn1.Unmarshal(info.Content.Bytes, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificates, err := x509.ParseCertificates(data.Certificates.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certificates) != 1 || !certificates[0].Equal(certificate) {
+		t.Fatal("Unexpected certificates:", certificates)
+	}
+
+	if len(data.SignerInfos) != 1 {
+		t.Fatal("Unexpected signer infos:", data.SignerInfos)
+	}
+
+	signer := data.SignerInfos[0]
+	if signer.IssuerAndSerial.Serial.Cmp(certificate.SerialNumber) != 0 || !bytes.Equal(signer.IssuerAndSerial.Issuer.FullBytes, certificate.RawIssuer) {
+		t.Fatal("Unexpected signer identifier:", signer.IssuerAndSerial.Serial)
+	}
+
+	digest := sha256.Sum256(content)
+	found := false
+
+	rest := signer.SignedAttributes.Bytes
+	for len(rest) > 0 {
+		attr := attribute{}
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !attr.Type.Equal(oidMessageDigest) {
+			continue
+		}
+
+		value := []byte{}
+		_, err = asn1.Unmarshal(attr.Values.Bytes, &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(value, digest[:]) {
+			t.Fatal("Unexpected message digest")
+		}
+
+		found = true
+	}
+
+	if !found {
+		t.Fatal("Message digest attribute not found")
+	}
+
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signer.SignedAttributes.Bytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	algorithm := x509.SHA256WithRSA
+	if signer.SignatureAlgorithm.Algorithm.Equal(oidECDSASHA256) {
+		algorithm = x509.ECDSAWithSHA256
+	}
+
+	err = certificate.CheckSignature(algorithm, set, signer.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCanonicalLines test if bare LF line endings are converted into CRLF
+func TestCanonicalLines(t *testing.T) {
+	result := string(canonicalLines([]byte("hello\nworld\r\n\n")))
+	if result != "hello"+CRLF+"world"+CRLF+CRLF {
+		t.Fatalf("Unexpected result: %q", result)
+	}
+}
@@ -0,0 +1,360 @@
+package postbox
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Failure represents the stage of a SMTP transaction in which a failure
+// occurred.
+type Failure string
+
+const (
+	// ConnectionFailure represents a failure while connecting to the SMTP
+	// server or negotiating STARTTLS.
+	ConnectionFailure Failure = "connection"
+	// AuthFailure represents a failure while authenticating with the SMTP
+	// server.
+	AuthFailure Failure = "auth"
+	// DeliveryFailure represents a failure while transmitting the message
+	// (ex: a rejected recipient).
+	DeliveryFailure Failure = "delivery"
+)
+
+// SendError is returned when a message could not be sent
+type SendError struct {
+	Failure Failure
+	Err     error
+}
+
+func (e *SendError) Error() string {
+	return string(e.Failure) + " failure: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary checks whether the failure is transient and the message could be
+// sent again. Transient negative SMTP replies (4xx) and network errors are
+// temporary, permanent negative replies (5xx) and message errors are not.
+func (e *SendError) Temporary() bool {
+	var reply *textproto.Error
+	if errors.As(e.Err, &reply) {
+		return reply.Code >= 400 && reply.Code < 500
+	}
+
+	var network net.Error
+	if errors.As(e.Err, &network) {
+		return true
+	}
+
+	// The server closed the connection
+	return errors.Is(e.Err, io.EOF)
+}
+
+// RetryConfig configures the SMTP server and retry behaviour used by
+// SendWithRetry
+type RetryConfig struct {
+	Addr string    // SMTP server address (ex: smtp.example.com:587)
+	Auth smtp.Auth // optional
+
+	// Attempts contains the maximum amount of send attempts,
+	// DefaultRetryAttempts is used when left empty
+	Attempts int
+
+	// Backoff is the delay before the first retry which is doubled after
+	// every attempt, DefaultRetryBackoff is used when left empty. The delay
+	// never exceeds MaxBackoff when set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryAttempts represents the default maximum amount of send attempts
+const DefaultRetryAttempts = 3
+
+// DefaultRetryBackoff represents the default delay before the first retry
+const DefaultRetryBackoff = time.Second
+
+// SendWithRetry sends the given envelope (see Send) and retries temporary
+// failures (see SendError.Temporary) using an exponential backoff. Permanent
+// failures are returned immediately. The error of the last attempt is
+// returned once all attempts failed. The envelope is rendered once, every
+// attempt transmits the same rendered message.
+func SendWithRetry(cfg RetryConfig, e *Envelope) error {
+	message, err := renderMessage(e)
+	if err != nil {
+		return &SendError{Failure: DeliveryFailure, Err: err}
+	}
+
+	attempts := cfg.Attempts
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+
+	backoff := cfg.Backoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := transmit(cfg.Addr, cfg.Auth, e, message)
+		if err == nil {
+			return nil
+		}
+
+		var failure *SendError
+		if attempt >= attempts || !errors.As(err, &failure) || !failure.Temporary() {
+			return err
+		}
+
+		if cfg.MaxBackoff > 0 && backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Send renders the given envelope and transmits it to the SMTP server at the
+// given address (ex: smtp.example.com:587). STARTTLS is negotiated when
+// advertised by the server. The given auth is optional and only used when set.
+// MAIL FROM is derived from the envelope ReturnPath, Sender or From address
+// (see EnvelopeFrom) and RCPT TO from the To, Cc and Bcc recipients (see
+// Recipients). A *SendError is returned on failure.
+func Send(addr string, auth smtp.Auth, e *Envelope) error {
+	message, err := renderMessage(e)
+	if err != nil {
+		return &SendError{Failure: DeliveryFailure, Err: err}
+	}
+
+	return transmit(addr, auth, e, message)
+}
+
+// transmit connects to the SMTP server at the given address and transmits
+// the given rendered message of the envelope. The message is delivered once
+// the server accepted the message content, a failure to gracefully end the
+// connection (QUIT) afterwards is ignored.
+func transmit(addr string, auth smtp.Auth, e *Envelope, message []byte) error {
+	client, err := dial(addr, auth)
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	err = deliver(client, e, message)
+	if err != nil {
+		return &SendError{Failure: DeliveryFailure, Err: err}
+	}
+
+	client.Quit()
+	return nil
+}
+
+// dial connects to the SMTP server at the given address, negotiates STARTTLS
+// when advertised and authenticates when the given auth is set. A *SendError
+// is returned on failure.
+func dial(addr string, auth smtp.Auth) (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, &SendError{Failure: ConnectionFailure, Err: err}
+	}
+
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return nil, &SendError{Failure: ConnectionFailure, Err: err}
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			client.Close()
+			return nil, &SendError{Failure: ConnectionFailure, Err: err}
+		}
+	}
+
+	if auth != nil {
+		err = client.Auth(auth)
+		if err != nil {
+			client.Close()
+			return nil, &SendError{Failure: AuthFailure, Err: err}
+		}
+	}
+
+	return client, nil
+}
+
+// renderMessage renders the given envelope into memory. Messages are rendered
+// before the mail transaction is started, a message which failed to render
+// halfway is therefore never (partially) transmitted and accepted.
+func renderMessage(e *Envelope) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	err := e.Write(&buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// deliver performs a single mail transaction for the given envelope
+// transmitting the given rendered message
+func deliver(client *smtp.Client, e *Envelope, message []byte) error {
+	from, err := e.envelopeFrom()
+	if err != nil {
+		return err
+	}
+
+	recipients, err := e.recipients()
+	if err != nil {
+		return err
+	}
+
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// EnvelopeFrom returns the address used as SMTP MAIL FROM without display
+// name. The ReturnPath is preferred over the Sender and the first From
+// address. Addresses which could not be parsed are returned as is.
+func (e *Envelope) EnvelopeFrom() string {
+	from, err := e.envelopeFrom()
+	if err != nil {
+		return strings.TrimSpace(e.from())
+	}
+
+	return from
+}
+
+// envelopeFrom returns the address used as SMTP MAIL FROM. An error is
+// returned when the address could not be parsed.
+func (e *Envelope) envelopeFrom() (string, error) {
+	addresses, err := ParseAddressList(e.from())
+	if err != nil {
+		return "", err
+	}
+
+	return addresses[0].Email, nil
+}
+
+// from returns the ReturnPath, Sender or From address in order of preference
+func (e *Envelope) from() string {
+	if e.ReturnPath != "" {
+		return e.ReturnPath
+	}
+
+	if e.Sender != "" {
+		return e.Sender
+	}
+
+	return e.author()
+}
+
+// Recipients returns the addresses of all To, Cc and Bcc recipients without
+// display names (ex: to be used as SMTP RCPT TO). Addresses are deduplicated
+// case-insensitively, the first occurrence is kept. Addresses which could not
+// be parsed are returned as is.
+func (e *Envelope) Recipients() []string {
+	result := make([]string, 0, len(e.To)+len(e.Cc)+len(e.Bcc))
+	seen := make(map[string]bool, cap(result))
+
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, recipient := range list {
+			email := recipientEmail(recipient)
+
+			key := strings.ToLower(email)
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			result = append(result, email)
+		}
+	}
+
+	return result
+}
+
+// DeduplicateRecipients removes duplicate addresses from the To, Cc and Bcc
+// recipients. Addresses are compared case-insensitively without display
+// names. The placement with the highest visibility is kept (To over Cc over
+// Bcc), the first occurrence is kept within a single list.
+func (e *Envelope) DeduplicateRecipients() {
+	seen := make(map[string]bool, len(e.To)+len(e.Cc)+len(e.Bcc))
+
+	for _, list := range []*[]string{&e.To, &e.Cc, &e.Bcc} {
+		if *list == nil {
+			continue
+		}
+
+		result := make([]string, 0, len(*list))
+		for _, recipient := range *list {
+			key := strings.ToLower(recipientEmail(recipient))
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			result = append(result, recipient)
+		}
+
+		*list = result
+	}
+}
+
+// recipientEmail returns the address of the given recipient without display
+// name. Recipients which could not be parsed are returned as is.
+func recipientEmail(recipient string) string {
+	address, err := ParseAddress(recipient)
+	if err != nil {
+		return strings.TrimSpace(recipient)
+	}
+
+	return address.Email
+}
+
+// recipients returns the deduplicated addresses of all To, Cc and Bcc
+// recipients. An error is returned when a recipient could not be parsed.
+func (e *Envelope) recipients() ([]string, error) {
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, recipient := range list {
+			_, err := ParseAddress(recipient)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return e.Recipients(), nil
+}
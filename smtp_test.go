@@ -0,0 +1,581 @@
+package postbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transaction represents a mail transaction received by the fake server
+type transaction struct {
+	from string
+	to   []string
+	data string
+}
+
+// server represents a fake SMTP server recording the received transactions.
+// The respond function could be used to override the reply of a command.
+type server struct {
+	listener     net.Listener
+	respond      func(command string) string
+	mutex        sync.Mutex
+	connections  int
+	transactions []transaction
+}
+
+// newServer starts a new fake SMTP server listening on a random local port
+func newServer(t *testing.T, respond func(command string) string) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &server{
+		listener: listener,
+		respond:  respond,
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go srv.serve()
+	return srv
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Transactions returns the completed mail transactions
+func (s *server) Transactions() []transaction {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.transactions
+}
+
+// Connections returns the amount of accepted connections
+func (s *server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connections
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.connections++
+		s.mutex.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			conn.Write([]byte(line + CRLF))
+		}
+	}
+
+	reply("220 localhost ESMTP")
+	current := transaction{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.TrimSuffix(line, CRLF)
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+
+		if s.respond != nil {
+			if response := s.respond(verb); response != "" {
+				reply(response)
+				continue
+			}
+		}
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-localhost", "250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 authenticated")
+		case "MAIL":
+			current = transaction{from: address(command)}
+			reply("250 OK")
+		case "RCPT":
+			current.to = append(current.to, address(command))
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+
+			data := ""
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if line == "."+CRLF {
+					break
+				}
+
+				data += line
+			}
+
+			current.data = data
+
+			s.mutex.Lock()
+			s.transactions = append(s.transactions, current)
+			s.mutex.Unlock()
+
+			reply("250 OK")
+		case "RSET", "NOOP":
+			current = transaction{}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+// address returns the address enclosed in angle brackets of the given command
+func address(command string) string {
+	start := strings.Index(command, "<")
+	end := strings.LastIndex(command, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return command[start+1 : end]
+}
+
+// TestSend test if the message is delivered to all recipients
+func TestSend(t *testing.T) {
+	srv := newServer(t, nil)
+	envelope := Envelope{
+		From:    "John Doe <john@example.com>",
+		To:      []string{"boss@example.com"},
+		Cc:      []string{"Dan <dan@example.com>"},
+		Bcc:     []string{"secret@example.com"},
+		Subject: "hello world",
+		Charset: "UTF-8",
+		Parts: []*Part{
+			{
+				ContentType: "text/plain",
+				Encoding:    QuotedPrintable,
+				Reader:      strings.NewReader("hello world"),
+			},
+		},
+	}
+
+	err := Send(srv.Addr(), smtp.PlainAuth("", "john", "secret", "127.0.0.1"), &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 1 {
+		t.Fatal("Unexpected transactions:", transactions)
+	}
+
+	result := transactions[0]
+	if result.from != "john@example.com" {
+		t.Fatal("Unexpected MAIL FROM:", result.from)
+	}
+
+	if strings.Join(result.to, ",") != "boss@example.com,dan@example.com,secret@example.com" {
+		t.Fatal("Unexpected RCPT TO:", result.to)
+	}
+
+	if !strings.Contains(result.data, "Subject: hello world"+CRLF) {
+		t.Fatal("Unexpected message:", result.data)
+	}
+}
+
+// TestSendFailures test if send failures are distinguished
+func TestSendFailures(t *testing.T) {
+	envelope := func() *Envelope {
+		return &Envelope{
+			From:    "john@example.com",
+			To:      []string{"boss@example.com"},
+			Charset: "UTF-8",
+			Parts:   text("hello world"),
+		}
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed.Close()
+
+	err = Send(closed.Addr().String(), nil, envelope())
+	assertFailure(t, err, ConnectionFailure)
+
+	srv := newServer(t, func(command string) string {
+		if command == "AUTH" {
+			return "535 invalid credentials"
+		}
+
+		return ""
+	})
+
+	err = Send(srv.Addr(), smtp.PlainAuth("", "john", "secret", "127.0.0.1"), envelope())
+	assertFailure(t, err, AuthFailure)
+
+	srv = newServer(t, func(command string) string {
+		if command == "RCPT" {
+			return "550 mailbox unavailable"
+		}
+
+		return ""
+	})
+
+	err = Send(srv.Addr(), nil, envelope())
+	assertFailure(t, err, DeliveryFailure)
+
+	var reply *textproto.Error
+	if !errors.As(err, &reply) || reply.Code != 550 {
+		t.Fatal("Unexpected delivery error:", err)
+	}
+}
+
+// TestSendRenderFailure test if no transaction is started once the message fails to render
+func TestSendRenderFailure(t *testing.T) {
+	srv := newServer(t, nil)
+	failure := errors.New("connection reset")
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+		Attachments: []*File{
+			{
+				Name: "report.pdf",
+				CopyFunc: func(writer io.Writer) error {
+					writer.Write([]byte("%PDF-1.4"))
+					return failure
+				},
+			},
+		},
+	}
+
+	err := Send(srv.Addr(), nil, &envelope)
+	assertFailure(t, err, DeliveryFailure)
+
+	if !errors.Is(err, failure) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(srv.Transactions()) != 0 {
+		t.Fatal("Unexpected transactions:", srv.Transactions())
+	}
+}
+
+// assertFailure asserts that the given error is a send error of the given failure
+func assertFailure(t *testing.T, err error, failure Failure) {
+	t.Helper()
+
+	var result *SendError
+	if !errors.As(err, &result) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if result.Failure != failure {
+		t.Fatal("Unexpected failure:", result.Failure, result.Err)
+	}
+}
+
+// TestSendWithRetry test if temporary failures are retried and permanent failures are not
+func TestSendWithRetry(t *testing.T) {
+	envelope := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: NewAlternative("hello world", "<p>hello world</p>"),
+	}
+
+	var mutex sync.Mutex
+	replies := []string{"451 try again later"}
+
+	srv := newServer(t, func(command string) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if command != "MAIL" || len(replies) == 0 {
+			return ""
+		}
+
+		reply := replies[0]
+		replies = replies[1:]
+		return reply
+	})
+
+	cfg := RetryConfig{Addr: srv.Addr(), Backoff: time.Millisecond}
+
+	err := SendWithRetry(cfg, envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.Connections() != 2 || len(srv.Transactions()) != 1 {
+		t.Fatal("Unexpected attempts:", srv.Connections(), srv.Transactions())
+	}
+
+	srv = newServer(t, func(command string) string {
+		if command == "RCPT" {
+			return "550 mailbox unavailable"
+		}
+
+		return ""
+	})
+
+	cfg.Addr = srv.Addr()
+
+	err = SendWithRetry(cfg, envelope)
+	assertFailure(t, err, DeliveryFailure)
+
+	if srv.Connections() != 1 {
+		t.Fatal("Unexpected attempts:", srv.Connections())
+	}
+
+	srv = newServer(t, func(command string) string {
+		if command == "MAIL" {
+			return "421 service not available"
+		}
+
+		return ""
+	})
+
+	cfg.Addr = srv.Addr()
+
+	err = SendWithRetry(cfg, envelope)
+	assertFailure(t, err, DeliveryFailure)
+
+	if srv.Connections() != DefaultRetryAttempts {
+		t.Fatal("Unexpected attempts:", srv.Connections())
+	}
+}
+
+// TestSendWithRetryDelivered test if a message accepted by the server is not sent again once QUIT fails
+func TestSendWithRetryDelivered(t *testing.T) {
+	envelope := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	srv := newServer(t, func(command string) string {
+		if command == "QUIT" {
+			return "421 service not available"
+		}
+
+		return ""
+	})
+
+	err := SendWithRetry(RetryConfig{Addr: srv.Addr(), Backoff: time.Millisecond}, envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.Transactions()) != 1 {
+		t.Fatal("Unexpected transactions:", srv.Transactions())
+	}
+}
+
+// TestSendWithRetryReader test if parts read only once are transmitted on every attempt
+func TestSendWithRetryReader(t *testing.T) {
+	envelope := &Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	var mutex sync.Mutex
+	replies := []string{"451 try again later"}
+
+	srv := newServer(t, func(command string) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if command != "DATA" || len(replies) == 0 {
+			return ""
+		}
+
+		reply := replies[0]
+		replies = replies[1:]
+		return reply
+	})
+
+	err := SendWithRetry(RetryConfig{Addr: srv.Addr(), Backoff: time.Millisecond}, envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transactions := srv.Transactions()
+	if srv.Connections() != 2 || len(transactions) != 1 {
+		t.Fatal("Unexpected attempts:", srv.Connections(), transactions)
+	}
+
+	if !strings.Contains(transactions[0].data, "hello world") {
+		t.Fatal("Unexpected message:", transactions[0].data)
+	}
+}
+
+// TestSendErrorTemporary test if transient failures are distinguished from permanent failures
+func TestSendErrorTemporary(t *testing.T) {
+	tests := map[error]bool{
+		&textproto.Error{Code: 421, Msg: "service not available"}: true,
+		&textproto.Error{Code: 452, Msg: "insufficient storage"}:  true,
+		&textproto.Error{Code: 550, Msg: "mailbox unavailable"}:   false,
+		&net.OpError{Op: "dial", Err: errors.New("refused")}:      true,
+		io.EOF:          true,
+		ErrNoRecipients: false,
+		fmt.Errorf("wrapped: %w", &textproto.Error{Code: 454}): true,
+	}
+
+	for err, expected := range tests {
+		failure := &SendError{Failure: DeliveryFailure, Err: err}
+		if failure.Temporary() != expected {
+			t.Fatal("Unexpected temporary state:", err, failure.Temporary())
+		}
+	}
+}
+
+// TestSendReturnPath test if MAIL FROM uses the return path when set
+func TestSendReturnPath(t *testing.T) {
+	srv := newServer(t, nil)
+	envelope := Envelope{
+		From:  "John Doe <john@example.com>",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	err := Send(srv.Addr(), nil, &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope.ReturnPath = "bounces@example.com"
+	envelope.Parts = text("hello world")
+
+	err = Send(srv.Addr(), nil, &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transactions := srv.Transactions()
+	if transactions[0].from != "john@example.com" || transactions[1].from != "bounces@example.com" {
+		t.Fatal("Unexpected MAIL FROM:", transactions[0].from, transactions[1].from)
+	}
+
+	if strings.Contains(transactions[1].data, "Return-Path") {
+		t.Fatal("Unexpected Return-Path header:", transactions[1].data)
+	}
+
+	envelope.EmitReturnPath = true
+	envelope.Parts = text("hello world")
+
+	headers, _ := render(t, &envelope)
+	if !strings.HasPrefix(headers, "Return-Path: <bounces@example.com>"+CRLF) {
+		t.Fatal("Return-Path header not found:", headers)
+	}
+}
+
+// TestEnvelopeRecipients test if the recipients are deduplicated and stripped of display names
+func TestEnvelopeRecipients(t *testing.T) {
+	envelope := Envelope{
+		To:  []string{"Boss <boss@example.com>", "jane@example.com"},
+		Cc:  []string{"=?utf-8?q?J=C3=B6hn?= <john@example.com>", "BOSS@example.com"},
+		Bcc: []string{" audit@example.com ", "Jane Doe <jane@example.com>"},
+	}
+
+	result := strings.Join(envelope.Recipients(), ",")
+	if result != "boss@example.com,jane@example.com,john@example.com,audit@example.com" {
+		t.Fatal("Unexpected recipients:", result)
+	}
+
+	envelope = Envelope{}
+	if len(envelope.Recipients()) != 0 {
+		t.Fatal("Unexpected recipients:", envelope.Recipients())
+	}
+}
+
+// TestEnvelopeDeduplicateRecipients test if duplicate recipients are kept in the most visible list
+func TestEnvelopeDeduplicateRecipients(t *testing.T) {
+	envelope := Envelope{
+		From: "john@example.com",
+		To:   []string{"Boss <boss@example.com>", "jane@example.com", "boss@example.com"},
+		Cc:   []string{"BOSS@example.com", "team@example.com"},
+		Bcc:  []string{" audit@example.com ", "Jane Doe <jane@example.com>", "team@example.com"},
+	}
+
+	envelope.DeduplicateRecipients()
+
+	if strings.Join(envelope.To, ",") != "Boss <boss@example.com>,jane@example.com" {
+		t.Fatal("Unexpected To recipients:", envelope.To)
+	}
+
+	if strings.Join(envelope.Cc, ",") != "team@example.com" {
+		t.Fatal("Unexpected Cc recipients:", envelope.Cc)
+	}
+
+	if strings.Join(envelope.Bcc, ",") != " audit@example.com " {
+		t.Fatal("Unexpected Bcc recipients:", envelope.Bcc)
+	}
+
+	envelope.Parts = text("hello world")
+	headers, _ := render(t, &envelope)
+
+	if strings.Count(headers, "boss@example.com") != 1 {
+		t.Fatal("Unexpected headers:", headers)
+	}
+}
+
+// TestEnvelopeFrom test if the envelope from address is selected in order of precedence
+func TestEnvelopeFrom(t *testing.T) {
+	envelope := Envelope{
+		From: "John Doe <john@example.com>, Jane Doe <jane@example.com>",
+	}
+
+	if envelope.EnvelopeFrom() != "john@example.com" {
+		t.Fatal("Unexpected envelope from:", envelope.EnvelopeFrom())
+	}
+
+	envelope.Sender = "Secretary <secretary@example.com>"
+	if envelope.EnvelopeFrom() != "secretary@example.com" {
+		t.Fatal("Unexpected envelope from:", envelope.EnvelopeFrom())
+	}
+
+	envelope.ReturnPath = "<bounces@example.com>"
+	if envelope.EnvelopeFrom() != "bounces@example.com" {
+		t.Fatal("Unexpected envelope from:", envelope.EnvelopeFrom())
+	}
+
+	envelope.ReturnPath = " invalid "
+	if envelope.EnvelopeFrom() != "invalid" {
+		t.Fatal("Unexpected envelope from:", envelope.EnvelopeFrom())
+	}
+}
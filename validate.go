@@ -0,0 +1,211 @@
+package postbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ValidationError is returned when an envelope violates one or more checks.
+// All violations are included.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for index, err := range e.Errors {
+		messages[index] = err.Error()
+	}
+
+	return "invalid envelope: " + strings.Join(messages, "; ")
+}
+
+// Is checks whether one of the violations matches the given target
+func (e *ValidationError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first violation matching the given target
+func (e *ValidationError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate runs all checks without rendering the message (ex: to gate messages
+// before sending). The required fields, addresses, part encodings, part
+// readers and the names and values of all written headers (including part
+// and file headers such as content types and file names) are checked.
+// Addresses are not validated when writing the message, strict callers could
+// validate the envelope before sending. A *ValidationError listing all
+// violations is returned.
+func (e *Envelope) Validate() error {
+	violations := e.requirements()
+
+	err := e.addresses()
+	if err != nil {
+		violations = append(violations, err)
+	}
+
+	headers := Headers{
+		"Subject":          {e.Subject},
+		"Message-ID":       {e.MessageID},
+		"In-Reply-To":      {e.InReplyTo},
+		"References":       e.References,
+		"Return-Path":      {e.ReturnPath},
+		"Auto-Submitted":   {string(e.AutoSubmitted)},
+		"Sensitivity":      {string(e.Sensitivity)},
+		"List-Unsubscribe": e.Unsubscribe,
+	}
+
+	for _, headers := range []Headers{headers, e.Headers} {
+		err = headers.Validate()
+		if err != nil {
+			violations = append(violations, err)
+		}
+	}
+
+	err = validateMessageHeaders(e.Headers)
+	if err != nil {
+		violations = append(violations, err)
+	}
+
+	for index, part := range e.Parts {
+		if part.Reader == nil && part.ReaderFunc == nil {
+			violations = append(violations, fmt.Errorf("part %d: %w", index+1, ErrNoReader))
+		}
+
+		// The values written as part headers are checked for header injection
+		headers := Headers{
+			"Content-Type":        {part.ContentType},
+			"Content-Description": {encodeHeader(part.Description)},
+			"Content-Disposition": append([]string{string(part.Disposition)}, encodeParam("filename", part.Filename)...),
+		}
+
+		checks := []error{part.Encoding.Validate(), part.Headers.Validate(), headers.Validate()}
+
+		language := part.Language
+		if language == "" {
+			language = e.Language
+		}
+
+		if language != "" {
+			checks = append(checks, ValidateLanguage(language))
+		}
+
+		for _, err := range checks {
+			if err != nil {
+				violations = append(violations, fmt.Errorf("part %d (%s): %w", index+1, part.ContentType, err))
+			}
+		}
+	}
+
+	files := []struct {
+		kind  string
+		files []*File
+	}{
+		{"embedded file", e.Embedded},
+		{"attachment", e.Attachments},
+	}
+
+	for _, group := range files {
+		for index, file := range group.files {
+			if file.CopyFunc == nil {
+				violations = append(violations, fmt.Errorf("%s %d: %w: %q", group.kind, index+1, ErrNoCopyFunc, file.Name))
+			}
+
+			err = file.encoding().Validate()
+			if err != nil {
+				violations = append(violations, fmt.Errorf("%s %d (%s): %w", group.kind, index+1, file.Name, err))
+			}
+
+			// The values written as file headers are checked for header
+			// injection
+			headers := Headers{
+				"Content-Description": {encodeHeader(file.Description)},
+				"Content-Disposition": encodeParam("filename", file.Name),
+				"Content-ID":          {file.ID()},
+			}
+
+			for _, headers := range []Headers{file.Header, headers} {
+				err = headers.Validate()
+				if err != nil {
+					violations = append(violations, fmt.Errorf("%s %d (%s): %w", group.kind, index+1, file.Name, err))
+				}
+			}
+		}
+	}
+
+	for index, raw := range e.RawParts {
+		if raw.Reader == nil && raw.ReaderFunc == nil {
+			violations = append(violations, fmt.Errorf("raw part %d: %w", index+1, ErrNoReader))
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Errors: violations}
+	}
+
+	return nil
+}
+
+// addresses checks whether all From, Sender, Reply-To, To, Cc and Bcc
+// addresses could be parsed. An *AddressError listing all invalid addresses
+// is returned.
+func (e *Envelope) addresses() error {
+	invalid := []InvalidAddress{}
+
+	check := func(field string, address string, list bool) {
+		var err error
+		if list {
+			_, err = ParseAddressList(address)
+		} else {
+			_, err = ParseAddress(address)
+		}
+
+		if err != nil {
+			invalid = append(invalid, InvalidAddress{Field: field, Address: address, Err: err})
+		}
+	}
+
+	if e.author() != "" {
+		check("From", e.author(), true)
+	}
+
+	if e.Sender != "" {
+		check("Sender", e.Sender, false)
+	}
+
+	fields := []struct {
+		name      string
+		addresses []string
+	}{
+		{"Reply-To", e.ReplyTo},
+		{"To", e.To},
+		{"Cc", e.Cc},
+		{"Bcc", e.Bcc},
+	}
+
+	for _, field := range fields {
+		for _, address := range field.addresses {
+			check(field.name, address, false)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return &AddressError{Addresses: invalid}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package postbox
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestEnvelopeValidate test if all violations are combined inside the returned error
+func TestEnvelopeValidate(t *testing.T) {
+	envelope := Envelope{
+		From:    "john@example.com, jane@example.com",
+		To:      []string{"boss@@example.com"},
+		Subject: "hello\r\nBcc: everyone@example.com",
+		Headers: Headers{"X-Mailer:": {"postbox"}},
+		Parts: []*Part{
+			{ContentType: "text/plain", Encoding: "7-bit", Reader: strings.NewReader("hello world")},
+		},
+		Attachments: []*File{
+			{Name: "report.pdf"},
+		},
+	}
+
+	err := envelope.Validate()
+
+	var result *ValidationError
+	if !errors.As(err, &result) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := []error{ErrNoSender, ErrInvalidAddress, ErrInvalidHeaderValue, ErrInvalidHeaderName, ErrUnknownEncoding, ErrNoCopyFunc}
+	for _, target := range expected {
+		if !errors.Is(err, target) {
+			t.Fatal("Expected violation not found:", target, err)
+		}
+	}
+
+	if len(result.Errors) != len(expected) {
+		t.Fatal("Unexpected violations:", err)
+	}
+
+	if errors.Is(err, ErrNoFrom) || errors.Is(err, ErrNoRecipients) || errors.Is(err, ErrEmptyMessage) {
+		t.Fatal("Unexpected violation:", err)
+	}
+
+	var addresses *AddressError
+	if !errors.As(err, &addresses) || addresses.Addresses[0].Address != "boss@@example.com" {
+		t.Fatal("Unexpected address error:", err)
+	}
+
+	err = (&Envelope{}).Validate()
+	for _, target := range []error{ErrNoFrom, ErrNoRecipients, ErrEmptyMessage} {
+		if !errors.Is(err, target) {
+			t.Fatal("Expected violation not found:", target, err)
+		}
+	}
+
+	envelope = Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: text("hello world"),
+	}
+
+	err = envelope.Validate()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestEnvelopeValidateAddresses test if all invalid addresses are listed inside the returned error
+func TestEnvelopeValidateAddresses(t *testing.T) {
+	envelope := Envelope{
+		From:    "John Doe <john@example.com>",
+		Parts:   text("hello world"),
+		ReplyTo: []string{"reply@example.com"},
+		To:      []string{"boss@example.com", "john@@example.com"},
+		Cc:      []string{"Jane <jane@example.com>"},
+		Bcc:     []string{"audit", "hidden@example.com"},
+	}
+
+	err := envelope.Validate()
+	if !errors.Is(err, ErrInvalidAddress) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var result *AddressError
+	if !errors.As(err, &result) {
+		t.Fatal("Unexpected error type:", err)
+	}
+
+	if len(result.Addresses) != 2 {
+		t.Fatal("Unexpected invalid addresses:", result.Addresses)
+	}
+
+	if result.Addresses[0].Field != "To" || result.Addresses[0].Address != "john@@example.com" {
+		t.Fatal("Unexpected invalid address:", result.Addresses[0])
+	}
+
+	if result.Addresses[1].Field != "Bcc" || result.Addresses[1].Address != "audit" {
+		t.Fatal("Unexpected invalid address:", result.Addresses[1])
+	}
+
+	for _, address := range []string{`To "john@@example.com"`, `Bcc "audit"`} {
+		if !strings.Contains(err.Error(), address) {
+			t.Fatal("Invalid address not listed:", err)
+		}
+	}
+
+	envelope.To = envelope.To[:1]
+	envelope.Bcc = envelope.Bcc[1:]
+
+	err = envelope.Validate()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestEnvelopeValidateInjection test if header injections rejected while writing are reported by Validate
+func TestEnvelopeValidateInjection(t *testing.T) {
+	injection := "\r\nBcc: everyone@example.com"
+	discard := func(w io.Writer) error {
+		return nil
+	}
+
+	tests := map[string]func(envelope *Envelope){
+		"content type": func(envelope *Envelope) {
+			envelope.Parts[0].ContentType += injection
+		},
+		"part filename": func(envelope *Envelope) {
+			envelope.Parts[0].Disposition = Attachment
+			envelope.Parts[0].Filename = "notes.txt" + injection
+		},
+		"file name": func(envelope *Envelope) {
+			envelope.Attachments = []*File{{Name: "report.pdf" + injection, CopyFunc: discard}}
+		},
+		"content id": func(envelope *Envelope) {
+			envelope.Embedded = []*File{{Name: "logo.png", ContentID: "logo" + injection, CopyFunc: discard}}
+		},
+		"unsubscribe": func(envelope *Envelope) {
+			envelope.Unsubscribe = []string{"mailto:unsubscribe@example.com" + injection}
+		},
+		"sensitivity": func(envelope *Envelope) {
+			envelope.Sensitivity = Sensitivity("Private" + injection)
+		},
+		"auto submitted": func(envelope *Envelope) {
+			envelope.AutoSubmitted = AutoSubmitted("auto-generated" + injection)
+		},
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			envelope := Envelope{
+				From:  "john@example.com",
+				To:    []string{"boss@example.com"},
+				Parts: text("hello world"),
+			}
+
+			modify(&envelope)
+
+			err := envelope.Validate()
+			if !errors.Is(err, ErrInvalidHeaderValue) {
+				t.Fatal("Unexpected error:", err)
+			}
+
+			err = envelope.Write(io.Discard)
+			if !errors.Is(err, ErrInvalidHeaderValue) {
+				t.Fatal("Unexpected write error:", err)
+			}
+		})
+	}
+
+	envelope := Envelope{
+		From:     "john@example.com",
+		To:       []string{"boss@example.com"},
+		Parts:    text("hello world"),
+		RawParts: []*RawPart{{}},
+	}
+
+	err := envelope.Validate()
+	if !errors.Is(err, ErrNoReader) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	err = envelope.Write(io.Discard)
+	if !errors.Is(err, ErrNoReader) {
+		t.Fatal("Unexpected write error:", err)
+	}
+}
+
+// TestEnvelopeValidateNoReader test if parts without a reader are reported by Validate and rejected while writing
+func TestEnvelopeValidateNoReader(t *testing.T) {
+	part := &Part{ContentType: "text/plain", Encoding: QuotedPrintable}
+	envelope := Envelope{
+		From:  "john@example.com",
+		To:    []string{"boss@example.com"},
+		Parts: []*Part{part},
+	}
+
+	err := envelope.Validate()
+	if !errors.Is(err, ErrNoReader) || !strings.Contains(err.Error(), "part 1: ") {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	err = part.Write(io.Discard, DefaultCharset)
+	if !errors.Is(err, ErrNoReader) {
+		t.Fatal("Unexpected part write error:", err)
+	}
+
+	err = envelope.Write(io.Discard)
+	if !errors.Is(err, ErrNoReader) {
+		t.Fatal("Unexpected write error:", err)
+	}
+}
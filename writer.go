@@ -0,0 +1,253 @@
+package postbox
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrHeadersWritten is returned when message headers are set once the
+// message headers have already been written
+var ErrHeadersWritten = errors.New("message headers have already been written")
+
+// ErrMessageClosed is returned when a part is added to a closed message
+var ErrMessageClosed = errors.New("message has been closed")
+
+// ErrPartClosed is returned when writing to a part once the next part has
+// been added or the message has been closed
+var ErrPartClosed = errors.New("message part has been closed")
+
+// MessageWriter writes a multipart/mixed message incrementally to the
+// underlying io.Writer. Part content is written directly to the writer
+// returned by AddPart and AddAttachment without holding the parts in memory,
+// making it a lower-level streaming alternative to Envelope. Headers are
+// written as is, address headers should be encoded by the caller.
+type MessageWriter struct {
+	writer   *stickyWriter
+	headers  Headers
+	boundary *Boundary
+	current  *partWriter
+	closed   bool
+
+	// Charset is written as charset parameter of parts added through AddPart,
+	// DefaultCharset is used when left empty
+	Charset string
+
+	// BoundaryFunc is used to generate the multipart boundary, RandomBoundary
+	// is used when left empty
+	BoundaryFunc func() string
+}
+
+// NewMessageWriter constructs a new message writer writing to the given
+// io.Writer. The writer is not closed once the message has been closed.
+func NewMessageWriter(writer io.Writer) *MessageWriter {
+	return &MessageWriter{
+		writer:  &stickyWriter{writer: writer},
+		headers: Headers{},
+	}
+}
+
+// Header sets the given message headers (ex: From, To, Subject). Headers
+// have to be set before the first part is added, ErrHeadersWritten is
+// returned otherwise. The MIME-Version header is added when not set. Content
+// headers (ex: Content-Type) are written by the multipart context and parts,
+// ErrContentHeader is returned when given.
+func (m *MessageWriter) Header(headers Headers) error {
+	if m.boundary != nil {
+		return ErrHeadersWritten
+	}
+
+	err := validateMessageHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	for property, values := range headers {
+		m.headers[canonicalKey(property)] = values
+	}
+
+	return nil
+}
+
+// AddPart closes the previous part and starts a new part of the given content
+// type. The returned writer encodes the written content using the given
+// transfer encoding and is valid until the next part is added or the message
+// is closed, ErrPartClosed is returned on write afterwards.
+func (m *MessageWriter) AddPart(contentType string, encoding Encoding) (io.Writer, error) {
+	err := encoding.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	charset := m.Charset
+	if charset == "" {
+		charset = DefaultCharset
+	}
+
+	headers := Headers{
+		"Content-Type":              {contentType, "charset=" + charset},
+		"Content-Transfer-Encoding": {string(encoding)},
+	}
+
+	return m.next(headers, encoding)
+}
+
+// AddAttachment closes the previous part and starts a new base64 encoded
+// attachment with the given file name and content type. DefaultContentType is
+// used when no content type is given. The returned writer is valid until the
+// next part is added or the message is closed, ErrPartClosed is returned on
+// write afterwards.
+func (m *MessageWriter) AddAttachment(name string, contentType string) (io.Writer, error) {
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+
+	headers := Headers{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {string(Base64)},
+		"Content-Disposition":       append([]string{string(Attachment)}, encodeParam("filename", name)...),
+	}
+
+	return m.next(headers, Base64)
+}
+
+// next closes the previous part and writes the headers of the next part. The
+// message headers are written before the first part.
+func (m *MessageWriter) next(headers Headers, encoding Encoding) (io.Writer, error) {
+	if m.closed {
+		return nil, ErrMessageClosed
+	}
+
+	err := m.start()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.flush()
+	if err != nil {
+		return nil, err
+	}
+
+	m.boundary.Mark()
+
+	err = headers.Write(m.writer)
+	if err != nil {
+		return nil, err
+	}
+
+	m.writer.Write(crlf)
+	m.current = &partWriter{
+		encoder: encode(newCollisionWriter(m.writer, m.boundary.Identifier), encoding, 0),
+	}
+
+	return m.current, m.writer.err
+}
+
+// start writes the message headers and starts the multipart context once
+func (m *MessageWriter) start() error {
+	if m.boundary != nil {
+		return nil
+	}
+
+	if _, has := m.headers["MIME-Version"]; !has {
+		m.headers["MIME-Version"] = []string{"1.0"}
+	}
+
+	err := m.headers.Write(m.writer)
+	if err != nil {
+		return err
+	}
+
+	identifier := ""
+	if m.BoundaryFunc != nil {
+		identifier = m.BoundaryFunc()
+	}
+
+	if identifier == "" {
+		identifier, err = GenerateBoundary()
+		if err != nil {
+			return err
+		}
+	}
+
+	boundary := StartBoundary(m.writer, identifier, "multipart/mixed")
+	m.boundary = &boundary
+
+	return m.writer.err
+}
+
+// flush closes the encoder of the current part
+func (m *MessageWriter) flush() error {
+	if m.current == nil {
+		return nil
+	}
+
+	err := m.current.close()
+	m.current = nil
+	if err != nil {
+		return err
+	}
+
+	m.writer.Write(crlf)
+	return m.writer.err
+}
+
+// Close closes the current part and ends the message. ErrEmptyMessage is
+// returned when no parts have been added.
+func (m *MessageWriter) Close() error {
+	if m.closed {
+		return ErrMessageClosed
+	}
+
+	m.closed = true
+
+	if m.boundary == nil {
+		return ErrEmptyMessage
+	}
+
+	err := m.flush()
+	if err != nil {
+		return err
+	}
+
+	m.boundary.End()
+	return m.writer.err
+}
+
+// partWriter is returned to the callers of AddPart and AddAttachment. Writes
+// are rejected once the part has been closed, the (pooled) encoder could
+// already be in use by another part.
+type partWriter struct {
+	encoder io.WriteCloser
+	closed  bool
+}
+
+func (p *partWriter) Write(b []byte) (int, error) {
+	if p.closed {
+		return 0, ErrPartClosed
+	}
+
+	return p.encoder.Write(b)
+}
+
+// close marks the part as closed and closes the encoder
+func (p *partWriter) close() error {
+	p.closed = true
+	return p.encoder.Close()
+}
+
+// stickyWriter records the first write error, all consecutive writes are
+// discarded once an error occurred
+type stickyWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (s *stickyWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n, err := s.writer.Write(p)
+	s.err = err
+	return n, err
+}
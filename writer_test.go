@@ -0,0 +1,209 @@
+package postbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestMessageWriter test if parts are streamed incrementally into a multipart message
+func TestMessageWriter(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+
+	writer := NewMessageWriter(buffer)
+	writer.BoundaryFunc = func() string { return "boundary" }
+
+	err := writer.Header(Headers{
+		"From":    {"john@example.com"},
+		"To":      {"boss@example.com"},
+		"subject": {"hello world"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := writer.AddPart("text/plain", QuotedPrintable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 3; index++ {
+		fmt.Fprintf(plain, "line %d\r\n", index)
+	}
+
+	html, err := writer.AddPart("text/html", QuotedPrintable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.WriteString(html, "<p>hello world</p>")
+
+	err = writer.Header(Headers{"Cc": {"team@example.com"}})
+	if !errors.Is(err, ErrHeadersWritten) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	attachment, err := writer.AddAttachment("report.csv", "text/csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 100; index++ {
+		fmt.Fprintf(attachment, "row,%d\n", index)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = writer.AddPart("text/plain", QuotedPrintable)
+	if !errors.Is(err, ErrMessageClosed) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	output := buffer.String()
+	result := structure(t, output)
+	if result != "multipart/mixed(text/plain,text/html,text/csv)" {
+		t.Fatal("Unexpected MIME tree:", result)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Header.Get("Subject") != "hello world" || parsed.Header.Get("MIME-Version") != "1.0" {
+		t.Fatal("Unexpected headers:", parsed.Header)
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	expected := []string{"line 0\r\nline 1\r\nline 2\r\n", "<p>hello world</p>"}
+
+	for _, content := range expected {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != content {
+			t.Fatalf("Unexpected part content: %q", body)
+		}
+	}
+
+	part, err := reader.NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, params, err = mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != "report.csv" {
+		t.Fatal("Unexpected disposition:", part.Header.Get("Content-Disposition"))
+	}
+
+	body, err := io.ReadAll(decode(part, Base64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(body), "row,0\nrow,1\n") || !strings.HasSuffix(string(body), "row,99\n") {
+		t.Fatalf("Unexpected attachment content: %q", body)
+	}
+}
+
+// TestMessageWriterEmpty test if closing a message without parts returns an error
+func TestMessageWriterEmpty(t *testing.T) {
+	writer := NewMessageWriter(io.Discard)
+
+	err := writer.Close()
+	if !errors.Is(err, ErrEmptyMessage) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestMessageWriterBoundaryCollision test if content containing the message boundary delimiter is rejected
+func TestMessageWriterBoundaryCollision(t *testing.T) {
+	writer := NewMessageWriter(io.Discard)
+	writer.BoundaryFunc = func() string { return "boundary" }
+
+	part, err := writer.AddPart("text/plain", Unencoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.WriteString(part, "hello world\r\n--boundary\r\n")
+	if !errors.Is(err, ErrBoundaryCollision) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// TestMessageWriterClosedPart test if writes to a part are rejected once the next part has been added
+func TestMessageWriterClosedPart(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	writer := NewMessageWriter(buffer)
+
+	first, err := writer.AddPart("application/octet-stream", Base64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := writer.AddPart("application/octet-stream", Base64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.WriteString(first, "STALE")
+	if !errors.Is(err, ErrPartClosed) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	_, err = io.WriteString(second, "hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.WriteString(second, "STALE")
+	if !errors.Is(err, ErrPartClosed) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if strings.Contains(buffer.String(), "U1RBTEU") {
+		t.Fatal("Stale content written:", buffer.String())
+	}
+}
+
+// TestMessageWriterContentHeaders test if content headers are rejected inside the message headers
+func TestMessageWriterContentHeaders(t *testing.T) {
+	writer := NewMessageWriter(io.Discard)
+
+	err := writer.Header(Headers{
+		"From":         {"john@example.com"},
+		"content-type": {"text/plain"},
+	})
+
+	if !errors.Is(err, ErrContentHeader) {
+		t.Fatal("Unexpected error:", err)
+	}
+}